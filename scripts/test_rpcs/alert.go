@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// stringsFlag collects a repeatable string flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// status classifies a result for change alerts.
+func (r result) status() string {
+	switch {
+	case !r.Reachable:
+		return "unreachable"
+	case !r.Archive:
+		return "reachable"
+	default:
+		return "archive"
+	}
+}
+
+// alert is the -alert-webhook payload. Latency alerts keep the endpoint's
+// status in both status fields.
+type alert struct {
+	ChainID    uint64  `json:"chain_id"`
+	URL        string  `json:"url"`
+	Kind       string  `json:"kind"` // "status" or "latency"
+	FromStatus string  `json:"from_status"`
+	ToStatus   string  `json:"to_status"`
+	Error      string  `json:"error,omitempty"`
+	LatencyMs  float64 `json:"latency_ms,omitempty"`
+	BaselineMs float64 `json:"baseline_ms,omitempty"` // rolling average the latency is compared to
+}
+
+// statusAlerts returns an alert for each endpoint whose status differs
+// between two cycles. Endpoints absent from prev are not reported.
+func statusAlerts(cid uint64, prev, cur []result) []alert {
+	before := map[string]string{}
+	for _, r := range prev {
+		before[r.URL] = r.status()
+	}
+	var alerts []alert
+	for _, r := range cur {
+		from, ok := before[r.URL]
+		if !ok || from == r.status() {
+			continue
+		}
+		alerts = append(alerts, alert{ChainID: cid, URL: r.URL, Kind: "status",
+			FromStatus: from, ToStatus: r.status(), Error: r.ErrorMsg})
+	}
+	return alerts
+}
+
+// latencyWindow is how many previous cycles form the latency baseline.
+const latencyWindow = 3
+
+// latencyAlerts compares each reachable endpoint's latency with the average
+// of its previous latencyWindow cycles, alerting when it rose by more than
+// pct percent, and then records it in history.
+func latencyAlerts(cid uint64, history map[string][]float64, cur []result, pct float64) []alert {
+	var alerts []alert
+	for _, r := range cur {
+		if !r.Reachable {
+			continue
+		}
+		h := history[r.URL]
+		if len(h) == latencyWindow {
+			var sum float64
+			for _, ms := range h {
+				sum += ms
+			}
+			if avg := sum / latencyWindow; r.LatencyMs > avg*(1+pct/100) {
+				alerts = append(alerts, alert{ChainID: cid, URL: r.URL, Kind: "latency",
+					FromStatus: r.status(), ToStatus: r.status(), LatencyMs: r.LatencyMs, BaselineMs: avg})
+			}
+			h = h[1:]
+		}
+		history[r.URL] = append(h, r.LatencyMs)
+	}
+	return alerts
+}
+
+// webhookTimeout bounds each -alert-webhook POST.
+const webhookTimeout = 5 * time.Second
+
+// sendAlerts POSTs each alert to every -alert-webhook URL, logging failures.
+func sendAlerts(alerts []alert) {
+	for _, a := range alerts {
+		body, _ := json.Marshal(a)
+		for _, hook := range opts.webhooks {
+			if err := postWebhook(hook, body); err != nil {
+				log.Printf("alert webhook %s: %v", hook, err)
+			}
+		}
+	}
+}
+
+func postWebhook(url string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	neturl "net/url"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// printChainComparison prints two chains' endpoints side by side, pairing
+// endpoints with the same hostname and listing the rest alone in their
+// chain's column.
+func printChainComparison(w io.Writer, a, b uint64, allResults map[uint64][]result) {
+	fmt.Fprintf(w, "\n%s\n  %s (chain %d) vs %s (chain %d)\n%s\n",
+		strings.Repeat("─", 90), chains[a].Name, a, chains[b].Name, b, strings.Repeat("─", 90))
+
+	type row struct {
+		host string
+		a, b *result
+	}
+	var rows []row
+	index := map[string]int{}
+	add := func(rs []result, right bool) {
+		for i := range rs {
+			r := &rs[i]
+			host := r.URL
+			if u, err := neturl.Parse(r.URL); err == nil && u.Hostname() != "" {
+				host = u.Hostname()
+			}
+			// Pair with an open slot for the same host, else start a row.
+			j, ok := index[host]
+			if ok && right && rows[j].b == nil {
+				rows[j].b = r
+				continue
+			}
+			if right {
+				rows = append(rows, row{host: host, b: r})
+			} else {
+				rows = append(rows, row{host: host, a: r})
+				if !ok {
+					index[host] = len(rows) - 1
+				}
+			}
+		}
+	}
+	ra, rb := allResults[a], allResults[b]
+	sortResults(ra)
+	sortResults(rb)
+	add(ra, false)
+	add(rb, true)
+	// Paired hosts first, then each chain's solo endpoints.
+	slices.SortStableFunc(rows, func(x, y row) int {
+		return btoi(x.a != nil && x.b != nil) - btoi(y.a != nil && y.b != nil)
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Host\t%s\t%s\n", chains[a].Name, chains[b].Name)
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.host, compareCell(r.a), compareCell(r.b))
+	}
+	tw.Flush()
+}
+
+// compareCell summarizes one endpoint as icon, latency and archive status.
+func compareCell(r *result) string {
+	switch {
+	case r == nil:
+		return ""
+	case !r.Reachable:
+		return r.icon() + " unreachable"
+	}
+	arc := "no archive"
+	if r.Archive {
+		arc = "archive"
+	}
+	return fmt.Sprintf("%s %.0fms %s", r.icon(), r.LatencyMs, arc)
+}
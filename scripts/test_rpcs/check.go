@@ -1,76 +1,875 @@
 package main
 
-import "encoding/json"
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
 
 type result struct {
-	URL       string
-	Reachable bool
-	LatencyMs float64
-	Archive   bool
-	Logs      int
-	MaxRange  int
-	Error     string
+	URL                   string    `json:"url"` // credentials stripped; see configURL
+	Reachable             bool      `json:"reachable"`
+	LatencyMs             float64   `json:"latency_ms"`
+	LatencyJitterMs       float64   `json:"latency_jitter_ms,omitempty"` // stddev of -check-jitter samples
+	LatestBlock           uint64    `json:"latest_block"`
+	BlockNumberConsistent bool      `json:"block_number_consistent"` // -check-interval-consistency saw no decreasing head
+	ChainID               uint64    `json:"chain_id,omitempty"`
+	Client                string    `json:"client_version,omitempty"`
+	Archive               bool      `json:"archive"`
+	Logs                  int       `json:"logs"`
+	MaxRange              int       `json:"max_range"`
+	MaxLogsPerResponse    int       `json:"max_logs_per_response"` // 0 when no cap was detected
+	Score                 float64   `json:"score"`                 // composite of -score-weights
+	Weight                int       `json:"weight"`                // load-balancer weight; see assignWeights
+	LogsByHashOK          bool      `json:"logs_by_hash_ok"`
+	GetProofOK            bool      `json:"get_proof_ok"`
+	StorageAtOK           bool      `json:"storage_at_ok"`      // non-zero slot 0 at the deploy block; historical state available
+	StateAtDeployOK       bool      `json:"state_at_deploy_ok"` // eth_getBalance answered at the deploy block
+	GetCodeOK             bool      `json:"get_code_ok"`        // eth_getCode returned the identity contract's bytecode at the deploy block
+	BlockReceiptsOK       bool      `json:"block_receipts_ok"`
+	BlockByHashOK         bool      `json:"block_by_hash_ok"` // eth_getBlockByHash agrees with eth_getBlockByNumber
+	TxByHashOK            bool      `json:"tx_by_hash_ok"`    // the transaction index serves a historical transaction
+	TxReceiptOK           bool      `json:"tx_receipt_ok"`    // the receipt, with its logs, was not pruned
+	Listening             bool      `json:"listening"`
+	PeerCount             int       `json:"peer_count"`         // -1 when net_peerCount is unavailable
+	PeerCountWarning      bool      `json:"peer_count_warning"` // zero peers; informational, as many providers hide theirs
+	TraceOK               bool      `json:"trace_ok"`
+	TraceReplayOK         bool      `json:"trace_replay_ok"`
+	SubscribeOK           bool      `json:"subscribe_ok"`
+	LogSubscribeOK        bool      `json:"log_subscribe_ok"`
+	LogSubscribeNote      string    `json:"log_subscribe_note,omitempty"`
+	CallOK                bool      `json:"call_ok"`
+	GasPrice              *big.Int  `json:"gas_price,omitempty"`        // wei; nil unless -gas-price-check
+	UncleOK               *bool     `json:"uncle_ok,omitempty"`         // nil when the chain has no sample uncle block
+	BlobBaseFeeOK         *bool     `json:"blob_base_fee_ok,omitempty"` // nil when the chain has no blob fee market
+	TxpoolPending         int       `json:"txpool_pending"`             // -1 when txpool_status is unavailable
+	TxpoolQueued          int       `json:"txpool_queued"`
+	AccountsExposed       bool      `json:"accounts_exposed"` // eth_accounts returned a non-empty list
+	PersonalExposed       bool      `json:"personal_exposed"` // personal_listAccounts answered without error
+	EngineExposed         bool      `json:"engine_exposed"`   // engine_ answered with something other than not-found or unauthorized
+	RawTx                 string    `json:"raw_tx,omitempty"` // -check-raw-tx: "broadcast", "read-only" or "unknown"
+	ReorgDetected         bool      `json:"reorg_detected"`
+	Error                 string    `json:"error,omitempty"`
+	ErrorCode             int       `json:"error_code,omitempty"` // JSON-RPC error code; 0 for transport or validation failures
+	ErrorMsg              string    `json:"error_msg,omitempty"`  // untruncated (up to 200 chars) form of Error
+	BurstMs               []float64 `json:"burst_ms,omitempty"`   // -rpc-call-count latencies; -1 for failed calls
+	Warnings              []string  `json:"warnings,omitempty"`
+	BytesSent             int64     `json:"bytes_sent"`
+	BytesRecv             int64     `json:"bytes_recv"`       // response bodies after decompression
+	CompressedBytes       int64     `json:"compressed_bytes"` // response bodies as transferred; equals BytesRecv without gzip
+	IP                    string    `json:"ip,omitempty"`
+	Country               string    `json:"country,omitempty"`
+	ASN                   string    `json:"asn,omitempty"`
+	ASName                string    `json:"as_name,omitempty"`
+	SharedASN             bool      `json:"shared_asn,omitempty"` // another endpoint of the chain is in the same AS
+	CDNProxy              bool      `json:"cdn_proxy"`            // a response carried a Cloudflare Cf-Ray header
+	Unstable              bool      `json:"unstable,omitempty"`   // archive status differs from the -diff-against run
+
+	// configURL is the entry as written in config.toml, credentials
+	// included, for rewriting the config.
+	configURL string
+}
+
+// setURL records the config entry u, keeping credentials out of URL.
+func (r *result) setURL(u string) {
+	r.URL, r.configURL = stripUserinfo(u), u
 }
 
-func checkPing(url string) (ok bool, ms float64, errMsg string) {
+// tomlURL is the URL to write back to config.toml.
+func (r result) tomlURL() string { return cmp.Or(r.configURL, r.URL) }
+
+// fail records why the endpoint failed.
+func (r *result) fail(e *rpcError) {
+	r.Error = truncate(e.Message, 60)
+	r.ErrorCode = e.Code
+	r.ErrorMsg = truncate(e.Message, 200)
+}
+
+// failure wraps a non-RPC error in an rpcError with code 0.
+func failure(msg string) *rpcError { return &rpcError{Message: msg} }
+
+// checkPing calls eth_blockNumber, returning the latency and the head block.
+func checkPing(url string) (ok bool, ms float64, head uint64, fail *rpcError) {
 	r, d, err := rpcCall(url, "eth_blockNumber", []any{})
 	if err != nil {
-		return false, 0, truncate(err.Error(), 60)
+		return false, 0, 0, failure(err.Error())
 	}
+	ms = float64(d.Milliseconds())
 	if r.Error != nil {
-		return false, float64(d.Milliseconds()), truncate(r.Error.Message, 60)
+		return false, ms, 0, r.Error
+	}
+	head, ok = parseHexUint(r.Result)
+	if !ok {
+		return false, ms, 0, failure("invalid block number " + truncate(string(r.Result), 40))
+	}
+	return true, ms, head, nil
+}
+
+// checkBurst issues n back-to-back eth_blockNumber calls over the shared
+// keep-alive client, returning each call's latency (-1 for failures) and the
+// number of failures.
+func checkBurst(url string, n int) (samples []float64, errs int) {
+	for range n {
+		ok, ms, _, _ := checkPing(url)
+		if !ok {
+			ms = -1
+			errs++
+		}
+		samples = append(samples, ms)
 	}
-	return true, float64(d.Milliseconds()), ""
+	return samples, errs
 }
 
-func checkArchive(url string, deploy uint64) (ok bool, nLogs int, errMsg string) {
-	r, _, err := rpcCall(url, "eth_getLogs", logFilter(deploy, deploy+100))
+// checkHeadConsistency calls eth_blockNumber three times back to back and
+// reports whether the heads never decrease. A proxy round-robining across
+// backends at different heights fails this.
+func checkHeadConsistency(url string) bool {
+	var last uint64
+	for range 3 {
+		ok, _, head, _ := checkPing(url)
+		if !ok || head < last {
+			return false
+		}
+		last = head
+	}
+	return true
+}
+
+// burstErrorPct is the share of failed -rpc-call-count calls above which an
+// endpoint gets a warning.
+const burstErrorPct = 10
+
+// jitterSamples is the number of pings -check-jitter takes when
+// -rpc-call-count does not already provide samples.
+const jitterSamples = 5
+
+// latencyJitter returns the standard deviation of the successful samples
+// (failed ones are -1), or 0 with fewer than two.
+func latencyJitter(samples []float64) float64 {
+	var n, sum, sumSq float64
+	for _, ms := range samples {
+		if ms >= 0 {
+			n++
+			sum += ms
+			sumSq += ms * ms
+		}
+	}
+	if n < 2 {
+		return 0
+	}
+	mean := sum / n
+	return math.Sqrt(max(sumSq/n-mean*mean, 0))
+}
+
+func checkArchive(url string, deploy uint64) (ok bool, nLogs int, fail *rpcError) {
+	r, _, err := rpcCall(url, "eth_getLogs", logFilter(deploy, addBlocks(deploy, 100)))
 	if err != nil {
-		return false, 0, truncate(err.Error(), 60)
+		return false, 0, failure(err.Error())
 	}
 	if r.Error != nil {
-		return false, 0, truncate(r.Error.Message, 60)
+		return false, 0, r.Error
 	}
 	var logs []json.RawMessage
 	if err := json.Unmarshal(r.Result, &logs); err != nil {
-		return false, 0, "invalid result"
+		return false, 0, failure("invalid result")
 	}
 	if len(logs) == 0 {
-		return false, 0, "0 logs at deploy block (silent drop)"
+		return false, 0, failure("0 logs at deploy block (silent drop)")
+	}
+	return true, len(logs), nil
+}
+
+// checkMeta returns the endpoint's chain ID and client version, from the
+// -cache-metadata cache when fresh. A chain ID of 0 means eth_chainId failed;
+// web3_clientVersion is often disabled and may be empty.
+func checkMeta(url string) endpointMeta {
+	if m, ok := endpointMetaCache.get(url); ok {
+		return m
+	}
+	var m endpointMeta
+	if r, _, err := rpcCall(url, "eth_chainId", []any{}); err == nil && r.Error == nil {
+		m.ChainID, _ = parseHexUint(r.Result)
+	}
+	if r, _, err := rpcCall(url, "web3_clientVersion", []any{}); err == nil && r.Error == nil {
+		json.Unmarshal(r.Result, &m.ClientVersion)
+	}
+	if m.ChainID != 0 {
+		endpointMetaCache.put(url, m)
+	}
+	return m
+}
+
+// checkTrace calls trace_transaction for the chain's -sample-tx when one is
+// given, and trace_block at the deploy block otherwise. The (potentially
+// large) trace payload is discarded; only a non-error reply matters.
+func checkTrace(url string, cid, deploy uint64) bool {
+	method, params := "trace_block", []any{toHex(deploy)}
+	if tx, ok := opts.sampleTxs[cid]; ok {
+		method, params = "trace_transaction", []any{tx}
+	}
+	r, _, err := rpcCall(url, method, params)
+	return err == nil && r.Error == nil && string(r.Result) != "null"
+}
+
+// ownerSelector is the ABI selector of owner().
+const ownerSelector = "0x8da5cb5b"
+
+// checkCall calls owner() on the identity contract at "latest" and expects a
+// non-zero 32-byte word back.
+func checkCall(url string) bool {
+	call := map[string]string{"to": identityAddr, "data": ownerSelector}
+	r, _, err := rpcCall(url, "eth_call", []any{call, "latest"})
+	if err != nil || r.Error != nil {
+		return false
+	}
+	var word string
+	if json.Unmarshal(r.Result, &word) != nil || len(word) != 66 {
+		return false
+	}
+	return strings.Trim(word[2:], "0") != ""
+}
+
+// checkGasPrice returns the node's eth_gasPrice in wei.
+func checkGasPrice(url string) (*big.Int, *rpcError) {
+	r, _, err := rpcCall(url, "eth_gasPrice", []any{})
+	if err != nil {
+		return nil, failure(err.Error())
+	}
+	if r.Error != nil {
+		return nil, r.Error
+	}
+	var s string
+	if json.Unmarshal(r.Result, &s) != nil {
+		return nil, failure("invalid gas price")
+	}
+	p, ok := new(big.Int).SetString(strings.TrimPrefix(s, "0x"), 16)
+	if !ok {
+		return nil, failure("invalid gas price " + s)
+	}
+	return p, nil
+}
+
+// checkFeeMarket validates gasPrice against the latest block of an EIP-1559
+// chain: it must be at least meta.MinGasLimit times the block's base fee.
+func checkFeeMarket(url string, meta chainMeta, gasPrice *big.Int) *rpcError {
+	b, fail := fetchBlock(url, "latest")
+	switch {
+	case fail != nil:
+		return fail
+	case b == nil:
+		return failure("latest block is null")
+	case b.BaseFeePerGas == 0:
+		return failure("latest block has no baseFeePerGas")
+	}
+	floor := new(big.Int).SetUint64(uint64(b.BaseFeePerGas))
+	floor.Mul(floor, new(big.Int).SetUint64(max(meta.MinGasLimit, 1)))
+	if gasPrice.Cmp(floor) < 0 {
+		return failure(fmt.Sprintf("gas price %s below %d × base fee %d", gasPrice, max(meta.MinGasLimit, 1), b.BaseFeePerGas))
+	}
+	return nil
+}
+
+// checkUncle fetches the first uncle of a block known to have one.
+func checkUncle(url string, block uint64) bool {
+	r, _, err := rpcCall(url, "eth_getUncleByBlockNumberAndIndex", []any{toHex(block), "0x0"})
+	return err == nil && r.Error == nil && string(r.Result) != "null"
+}
+
+// checkBlobBaseFee calls eth_blobBaseFee and expects a hex quantity back.
+func checkBlobBaseFee(url string) bool {
+	r, _, err := rpcCall(url, "eth_blobBaseFee", []any{})
+	if err != nil || r.Error != nil {
+		return false
+	}
+	_, ok := parseHexUint(r.Result)
+	return ok
+}
+
+// checkTxpool returns the pending and queued counts from Geth's
+// txpool_status, or -1 for both when the namespace is unavailable.
+func checkTxpool(url string) (pending, queued int) {
+	r, _, err := rpcCall(url, "txpool_status", []any{})
+	if err != nil || r.Error != nil {
+		return -1, -1
+	}
+	var st struct{ Pending, Queued hexUint }
+	if json.Unmarshal(r.Result, &st) != nil {
+		return -1, -1
+	}
+	return int(st.Pending), int(st.Queued)
+}
+
+// checkAccounts returns how many accounts eth_accounts lists. Public nodes
+// should list none or not serve the method at all.
+func checkAccounts(url string) int {
+	r, _, err := rpcCall(url, "eth_accounts", []any{})
+	if err != nil || r.Error != nil {
+		return 0
+	}
+	var accounts []string
+	json.Unmarshal(r.Result, &accounts)
+	return len(accounts)
+}
+
+// checkLogsByHash queries the deploy block's logs by blockHash and expects
+// the same logs as the equivalent fromBlock/toBlock query.
+func checkLogsByHash(url string, deploy uint64) bool {
+	b, fail := fetchBlock(url, toHex(deploy))
+	if fail != nil || b == nil {
+		return false
+	}
+	byHash, _, err := rpcCall(url, "eth_getLogs", []any{map[string]string{
+		"address":   identityAddr,
+		"blockHash": b.Hash,
+	}})
+	if err != nil || byHash.Error != nil {
+		return false
+	}
+	byRange, _, err := rpcCall(url, "eth_getLogs", logFilter(deploy, deploy))
+	if err != nil || byRange.Error != nil {
+		return false
+	}
+	var h, r []json.RawMessage
+	if json.Unmarshal(byHash.Result, &h) != nil || json.Unmarshal(byRange.Result, &r) != nil {
+		return false
+	}
+	return slices.EqualFunc(h, r, func(a, b json.RawMessage) bool { return bytes.Equal(a, b) })
+}
+
+// checkPersonal reports whether personal_listAccounts answers without an
+// error, i.e. the node exposes account management.
+func checkPersonal(url string) bool {
+	r, _, err := rpcCall(url, "personal_listAccounts", []any{})
+	return err == nil && r.Error == nil
+}
+
+// JSON-RPC error codes a node not exposing engine_ may answer with.
+const (
+	errMethodNotFound = -32601
+	errUnauthorized   = -32001
+)
+
+// checkEngine reports whether the consensus-layer engine_ namespace is
+// reachable: engine_forkchoiceUpdatedV1 with no params must fail with
+// method-not-found or unauthorized. Any other JSON-RPC answer, even an
+// invalid-params error, shows the method is served. Transport errors such
+// as HTTP 401 count as not exposed.
+func checkEngine(url string) bool {
+	r, _, err := rpcCall(url, "engine_forkchoiceUpdatedV1", []any{})
+	if err != nil {
+		return false
+	}
+	return r.Error == nil || (r.Error.Code != errMethodNotFound && r.Error.Code != errUnauthorized)
+}
+
+// checkGetProof requests a Merkle proof of the identity contract's slot 0 at
+// the deploy block and expects both proof arrays to be non-empty.
+func checkGetProof(url string, deploy uint64) bool {
+	r, _, err := rpcCall(url, "eth_getProof", []any{identityAddr, []string{"0x0"}, toHex(deploy)})
+	if err != nil || r.Error != nil {
+		return false
+	}
+	var p struct {
+		AccountProof []string          `json:"accountProof"`
+		StorageProof []json.RawMessage `json:"storageProof"`
+	}
+	if json.Unmarshal(r.Result, &p) != nil {
+		return false
+	}
+	return len(p.AccountProof) > 0 && len(p.StorageProof) > 0
+}
+
+// checkListening reports whether net_listening returns true.
+func checkListening(url string) bool {
+	r, _, err := rpcCall(url, "net_listening", []any{})
+	return err == nil && r.Error == nil && string(r.Result) == "true"
+}
+
+// checkPeerCount returns net_peerCount, or -1 when the call fails.
+func checkPeerCount(url string) int {
+	r, _, err := rpcCall(url, "net_peerCount", []any{})
+	if err != nil || r.Error != nil {
+		return -1
+	}
+	n, ok := parseHexUint(r.Result)
+	if !ok {
+		return -1
+	}
+	return int(n)
+}
+
+// checkStorageAt reads slot 0 of the identity contract at the deploy block
+// and expects a non-zero word; nodes without historical state return zero.
+func checkStorageAt(url string, deploy uint64) bool {
+	r, _, err := rpcCall(url, "eth_getStorageAt", []any{identityAddr, "0x0", toHex(deploy)})
+	if err != nil || r.Error != nil {
+		return false
+	}
+	var word string
+	if json.Unmarshal(r.Result, &word) != nil || !strings.HasPrefix(word, "0x") {
+		return false
+	}
+	return strings.Trim(word[2:], "0") != ""
+}
+
+// checkStateAtDeploy reports whether eth_getBalance of the identity contract
+// succeeds at the deploy block. Any balance, zero included, passes; an error
+// means the node lacks state that old.
+func checkStateAtDeploy(url string, deploy uint64) bool {
+	r, _, err := rpcCall(url, "eth_getBalance", []any{identityAddr, toHex(deploy)})
+	if err != nil || r.Error != nil {
+		return false
+	}
+	// Balances can exceed uint64, so only the quantity's form is checked.
+	var bal string
+	return json.Unmarshal(r.Result, &bal) == nil && strings.HasPrefix(bal, "0x")
+}
+
+// checkGetCode reports whether eth_getCode of the identity contract at the
+// deploy block returns bytecode. "0x" means the node has no code there,
+// usually because it lacks state that old.
+func checkGetCode(url string, deploy uint64) bool {
+	r, _, err := rpcCall(url, "eth_getCode", []any{identityAddr, toHex(deploy)})
+	if err != nil || r.Error != nil {
+		return false
+	}
+	var code string
+	return json.Unmarshal(r.Result, &code) == nil && strings.HasPrefix(code, "0x") && len(code) > 2
+}
+
+// checkBlockByHash fetches the deploy block by number, then by its hash, and
+// reports whether both lookups return the same block. Pruned or badly
+// migrated nodes can have the two indexes disagree.
+func checkBlockByHash(url string, deploy uint64) bool {
+	b, fail := fetchBlock(url, toHex(deploy))
+	if fail != nil || b == nil || b.Hash == "" {
+		return false
+	}
+	h, fail := fetchBlockByHash(url, b.Hash)
+	return fail == nil && h != nil && uint64(h.Number) == deploy && strings.EqualFold(h.Hash, b.Hash)
+}
+
+// checkBlockReceipts reports whether eth_getBlockReceipts returns the
+// receipts of the deploy block. Recent Geth and Erigon serve it; many
+// providers do not.
+func checkBlockReceipts(url string, deploy uint64) bool {
+	r, _, err := rpcCall(url, "eth_getBlockReceipts", []any{toHex(deploy)})
+	if err != nil || r.Error != nil {
+		return false
+	}
+	var receipts []json.RawMessage
+	return json.Unmarshal(r.Result, &receipts) == nil && receipts != nil
+}
+
+// sampleTxHash picks the historical transaction probed by -check-tx-by-hash
+// and -check-tx-receipt: the chain's -sample-tx, else chainMeta.SampleTx,
+// else the first transaction of the deploy block, which always contains the
+// deployment.
+func sampleTxHash(url string, cid uint64, meta chainMeta, deploy uint64) (string, bool) {
+	if tx := cmp.Or(opts.sampleTxs[cid], meta.SampleTx); tx != "" {
+		return tx, true
+	}
+	b, fail := fetchBlock(url, toHex(deploy))
+	if fail != nil || b == nil || len(b.Transactions) == 0 {
+		return "", false
+	}
+	return b.Transactions[0], true
+}
+
+// checkTxByHash reports whether eth_getTransactionByHash finds tx.
+func checkTxByHash(url, tx string) bool {
+	r, _, err := rpcCall(url, "eth_getTransactionByHash", []any{tx})
+	return err == nil && r.Error == nil && string(r.Result) != "null"
+}
+
+// checkTxReceipt reports whether eth_getTransactionReceipt returns tx's
+// receipt with a logs array. Providers that prune receipts answer null.
+func checkTxReceipt(url, tx string) bool {
+	r, _, err := rpcCall(url, "eth_getTransactionReceipt", []any{tx})
+	if err != nil || r.Error != nil {
+		return false
+	}
+	var receipt *struct {
+		Logs []json.RawMessage `json:"logs"`
+	}
+	return json.Unmarshal(r.Result, &receipt) == nil && receipt != nil && receipt.Logs != nil
+}
+
+// checkHistory reports whether the node returns the given block with its
+// transactions; pruned nodes return null for blocks they no longer hold.
+func checkHistory(url string, block uint64) bool {
+	r, _, err := rpcCall(url, "eth_getBlockByNumber", []any{toHex(block), true})
+	return err == nil && r.Error == nil && string(r.Result) != "null"
+}
+
+// checkTraceReplay calls trace_replayBlockTransactions with a vmTrace at the
+// deploy block, allowing slowClient's longer timeout.
+func checkTraceReplay(url string, deploy uint64) bool {
+	r, _, err := rpcCallVia(slowClient, url, "trace_replayBlockTransactions", []any{toHex(deploy), []string{"vmTrace"}})
+	return err == nil && r.Error == nil && string(r.Result) != "null"
+}
+
+// checkRawTx submits an empty raw transaction, which every node must reject.
+// Rejecting it as invalid (-32000 or -32602) means the node accepts
+// submissions ("broadcast"); method-not-found (-32601) means it does not
+// ("read-only"). Anything else is reported as "unknown".
+func checkRawTx(url string) string {
+	r, _, err := rpcCall(url, "eth_sendRawTransaction", []any{"0x"})
+	if err != nil || r.Error == nil {
+		return "unknown"
+	}
+	switch r.Error.Code {
+	case -32000, -32602:
+		return "broadcast"
+	case -32601:
+		return "read-only"
+	default:
+		return "unknown"
+	}
+}
+
+// logCapRe extracts the cap from errors like "query returned more than
+// 10000 results".
+var logCapRe = regexp.MustCompile(`more than (\d+) (?:results|logs)`)
+
+// logLimitRe matches eth_getLogs errors about the size of the result rather
+// than the block range, e.g. "Log response size exceeded".
+var logLimitRe = regexp.MustCompile(`(?i)\b(results|logs|response size)\b`)
+
+// checkMaxLogs estimates a node's cap on logs per eth_getLogs response from
+// the identity contract's logs over doubling spans from the deploy block. An
+// error naming a result limit gives the cap directly. A count of at least
+// minLogCap that stops growing while the span doubles is a plateau at the
+// cap. An unnamed result-size error bisects between the last passing and the
+// first failing span; the largest count that passed is the cap. It returns 0
+// when none of these shows up within maxLogSpan blocks. Smaller plateaus are
+// more likely quiet blocks than a cap.
+func checkMaxLogs(url string, deploy uint64) int {
+	const maxLogSpan, minLogCap = 8192, 100
+	// probe returns the log count over span blocks, or the cap named by the
+	// error, or fail when the node rejected the query for its result size.
+	probe := func(span uint64) (n, named int, fail bool) {
+		r, _, err := rpcCall(url, "eth_getLogs", logFilter(deploy, addBlocks(deploy, span-1)))
+		if err != nil {
+			return -1, 0, false
+		}
+		if r.Error != nil {
+			if m := logCapRe.FindStringSubmatch(r.Error.Message); m != nil {
+				named, _ = strconv.Atoi(m[1])
+				return 0, named, false
+			}
+			return -1, 0, logLimitRe.MatchString(r.Error.Message)
+		}
+		var logs []json.RawMessage
+		if json.Unmarshal(r.Result, &logs) != nil {
+			return -1, 0, false
+		}
+		return len(logs), 0, false
+	}
+
+	// lo is the last span that passed, with count logs; hi the first that
+	// failed.
+	var lo, hi uint64
+	count := -1
+	for span := uint64(1); span <= maxLogSpan; span *= 2 {
+		n, named, fail := probe(span)
+		switch {
+		case named > 0:
+			return named
+		case fail:
+			hi = span
+		case n < 0:
+			return 0
+		case n >= minLogCap && n == count:
+			return n
+		default:
+			lo, count = span, n
+			continue
+		}
+		break
+	}
+	if hi == 0 {
+		return 0
+	}
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		n, named, fail := probe(mid)
+		switch {
+		case named > 0:
+			return named
+		case fail:
+			hi = mid
+		case n < 0:
+			return 0
+		default:
+			lo, count = mid, n
+		}
+	}
+	if count < minLogCap {
+		return 0
+	}
+	return count
+}
+
+// checkReorg samples the head block twice, two seconds apart, and reports
+// whether the second sample contradicts the first: a different hash at the
+// same height, or a parent hash that does not match the first block. Heads
+// more than one block apart cannot be compared and report false.
+func checkReorg(url string) bool {
+	first, fail := fetchBlock(url, "latest")
+	if fail != nil || first == nil {
+		return false
+	}
+	time.Sleep(2 * time.Second)
+	second, fail := fetchBlock(url, "latest")
+	if fail != nil || second == nil {
+		return false
+	}
+	switch second.Number {
+	case first.Number:
+		return second.Hash != first.Hash
+	case first.Number + 1:
+		return second.ParentHash != first.Hash
+	default:
+		return false
 	}
-	return true, len(logs), ""
 }
 
 var rangeSteps = []int{500, 2_000, 5_000, 10_000, 50_000}
 
+// parseRangeSteps parses a comma-separated list of strictly increasing,
+// positive block spans for checkMaxRange.
+func parseRangeSteps(s string) ([]int, error) {
+	var steps []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid step %q", f)
+		}
+		if len(steps) > 0 && n <= steps[len(steps)-1] {
+			return nil, fmt.Errorf("steps must be strictly increasing: %d after %d", n, steps[len(steps)-1])
+		}
+		steps = append(steps, n)
+	}
+	return steps, nil
+}
+
+// largeRangeSteps extend the probe under -check-large-log-range once every
+// regular step has succeeded.
+var largeRangeSteps = []int{100_000, 200_000, 500_000, 1_000_000}
+
 func checkMaxRange(url string, deploy uint64) int {
+	steps := rangeSteps
+	if opts.largeLogRange {
+		steps = slices.Clip(steps)
+		for _, n := range largeRangeSteps {
+			if n > steps[len(steps)-1] {
+				steps = append(steps, n)
+			}
+		}
+	}
+	// Probe up to -max-parallel-probes steps at a time. A batch is only
+	// trusted up to its first failure, so the result matches a sequential
+	// probe.
 	best := 0
-	for _, r := range rangeSteps {
-		resp, _, err := rpcCall(url, "eth_getLogs", logFilter(deploy, deploy+uint64(r)))
-		if err != nil || resp.Error != nil {
-			break
+	for batch := range slices.Chunk(steps, max(opts.maxParallelProbes, 1)) {
+		ok := make([]bool, len(batch))
+		var wg sync.WaitGroup
+		for i, r := range batch {
+			wg.Go(func() {
+				resp, _, err := rpcCall(url, "eth_getLogs", logFilter(deploy, addBlocks(deploy, uint64(r))))
+				ok[i] = err == nil && resp.Error == nil
+			})
+		}
+		wg.Wait()
+		for i, r := range batch {
+			if !ok[i] {
+				return best
+			}
+			best = r
 		}
-		best = r
 	}
 	return best
 }
 
-func testEndpoint(url string, deploy uint64) result {
-	ok, ms, err := checkPing(url)
+func testEndpoint(url string, cid uint64, meta chainMeta) (res result) {
+	deploy := meta.DeployBlock
+	traffic.Store(url, new(endpointTraffic))
+	defer func() {
+		t := trafficFor(url)
+		res.BytesSent, res.BytesRecv, res.CDNProxy = t.sent.Load(), t.recv.Load(), t.cdn.Load()
+		res.CompressedBytes = t.wire.Load()
+	}()
+
+	res = result{URL: displayURL(url)}
+	ok, ms, head, fail := checkPing(url)
 	if !ok {
-		return result{URL: url, Error: err}
+		res.fail(fail)
+		return res
+	}
+	res.Reachable, res.LatencyMs, res.LatestBlock = true, ms, head
+
+	if opts.rpcCallCount > 0 {
+		var errs int
+		res.BurstMs, errs = checkBurst(url, opts.rpcCallCount)
+		if errs*100 > opts.rpcCallCount*burstErrorPct {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("%d/%d burst calls failed", errs, opts.rpcCallCount))
+		}
+	}
+	if opts.checkJitter {
+		samples := res.BurstMs
+		if len(samples) < 2 {
+			samples, _ = checkBurst(url, jitterSamples)
+		}
+		res.LatencyJitterMs = latencyJitter(samples)
+	}
+
+	if opts.checkConsistency {
+		res.BlockNumberConsistent = checkHeadConsistency(url)
+		if !res.BlockNumberConsistent {
+			res.Warnings = append(res.Warnings, "eth_blockNumber went backwards")
+		}
+	}
+
+	m := checkMeta(url)
+	res.ChainID, res.Client = m.ChainID, m.ClientVersion
+	if m.ChainID != 0 && m.ChainID != cid {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("wrong chain: eth_chainId is %d", m.ChainID))
+	}
+
+	if opts.checkTrace {
+		res.TraceOK = checkTrace(url, cid, deploy)
+	}
+	if opts.checkTraceReplay {
+		res.TraceReplayOK = checkTraceReplay(url, deploy)
+	}
+	if opts.checkSubscribe {
+		res.SubscribeOK = checkSubscribe(url)
+	}
+	if opts.checkSubscribeLogs {
+		res.LogSubscribeOK, res.LogSubscribeNote = checkSubscribeLogs(url)
+	}
+	if opts.checkCall {
+		res.CallOK = checkCall(url)
+	}
+	if opts.checkGasPrice {
+		p, fail := checkGasPrice(url)
+		switch {
+		case fail != nil:
+			res.fail(fail)
+		case p.Sign() == 0 && !meta.ZeroGas:
+			res.fail(failure("zero gas price"))
+		case meta.IsEIP1559:
+			if fail := checkFeeMarket(url, meta, p); fail != nil {
+				res.fail(fail)
+			}
+		}
+		res.GasPrice = p
 	}
-	arc, n, err := checkArchive(url, deploy)
+	if opts.checkTxpool {
+		res.TxpoolPending, res.TxpoolQueued = checkTxpool(url)
+	}
+	if opts.checkAccounts {
+		if n := checkAccounts(url); n > 0 {
+			res.AccountsExposed = true
+			res.Warnings = append(res.Warnings, fmt.Sprintf("eth_accounts lists %d accounts", n))
+		}
+	}
+	if opts.checkLogsByHash {
+		res.LogsByHashOK = checkLogsByHash(url, deploy)
+	}
+	if opts.checkPersonal && checkPersonal(url) {
+		res.PersonalExposed = true
+		res.Warnings = append(res.Warnings, "personal namespace exposed")
+		log.Printf("warning: %s exposes the personal_ namespace", displayURL(url))
+	}
+	if opts.checkEngine && checkEngine(url) {
+		res.EngineExposed = true
+		res.Warnings = append(res.Warnings, "SECURITY: engine namespace exposed")
+		log.Printf("SECURITY WARNING: %s exposes the engine_ namespace", displayURL(url))
+	}
+	if opts.checkGetProof {
+		res.GetProofOK = checkGetProof(url, deploy)
+	}
+	if opts.checkListening {
+		res.Listening = checkListening(url)
+	}
+	if opts.checkPeerCount {
+		res.PeerCount = checkPeerCount(url)
+		res.PeerCountWarning = res.PeerCount == 0
+	}
+	if opts.checkStorageAt {
+		res.StorageAtOK = checkStorageAt(url, deploy)
+	}
+	if opts.checkStateAtDeploy {
+		res.StateAtDeployOK = checkStateAtDeploy(url, deploy)
+	}
+	if opts.checkGetCode {
+		res.GetCodeOK = checkGetCode(url, deploy)
+	}
+	if opts.checkBlockByHash {
+		res.BlockByHashOK = checkBlockByHash(url, deploy)
+	}
+	if opts.checkBlockReceipts {
+		res.BlockReceiptsOK = checkBlockReceipts(url, deploy)
+	}
+	if opts.checkTxByHash || opts.checkTxReceipt {
+		if tx, ok := sampleTxHash(url, cid, meta, deploy); ok {
+			res.TxByHashOK = opts.checkTxByHash && checkTxByHash(url, tx)
+			res.TxReceiptOK = opts.checkTxReceipt && checkTxReceipt(url, tx)
+		}
+	}
+	if opts.checkRawTx {
+		res.RawTx = checkRawTx(url)
+	}
+	if opts.checkReorg {
+		res.ReorgDetected = checkReorg(url)
+	}
+	if opts.checkUncle && meta.SampleUncleBlock > 0 {
+		ok := checkUncle(url, meta.SampleUncleBlock)
+		res.UncleOK = &ok
+	}
+	if opts.checkBlob && meta.SupportsBlobFee {
+		ok := checkBlobBaseFee(url)
+		res.BlobBaseFeeOK = &ok
+	}
+
+	arc, n, fail := checkArchive(url, deploy)
 	if !arc {
-		return result{URL: url, Reachable: true, LatencyMs: ms, Error: err}
+		res.fail(fail)
+		return res
 	}
-	mx := checkMaxRange(url, deploy)
-	return result{URL: url, Reachable: true, LatencyMs: ms, Archive: true, Logs: n, MaxRange: mx}
+	if depth := opts.minArchiveDepth; depth > 0 && !checkHistory(url, head-min(depth, head)) {
+		res.Logs = n
+		res.fail(failure(fmt.Sprintf("no block %d blocks below head", depth)))
+		return res
+	}
+	res.Archive, res.Logs = true, n
+	res.MaxRange = checkMaxRange(url, deploy)
+	if opts.checkMaxLogs {
+		res.MaxLogsPerResponse = checkMaxLogs(url, deploy)
+	}
+	return res
 }
 
+// truncate cuts s to at most n bytes without splitting a multi-byte rune.
 func truncate(s string, n int) string {
-	if len(s) > n {
-		return s[:n]
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
 	}
-	return s
+	return s[:n]
 }
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+const testDeploy = 1_000
+
+// rawServer answers every JSON-RPC call with the given HTTP status and body.
+func rawServer(t *testing.T, status int, body string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// mockServer starts a mockNode, optionally delaying every response.
+func mockServer(t *testing.T, m mockNode, delay time.Duration) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		m.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// deadURL returns the URL of a server that has already been shut down.
+func deadURL(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	return srv.URL
+}
+
+var healthy = mockNode{ChainID: 1, Head: 100_000, Logs: 3}
+
+func TestCheckPing(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		wantOK   bool
+		minMs    float64
+		wantCode int
+		wantMsg  string
+	}{
+		{name: "healthy", url: mockServer(t, healthy, 0), wantOK: true},
+		{name: "slow", url: mockServer(t, healthy, 30*time.Millisecond), wantOK: true, minMs: 30},
+		{name: "rpc error", url: rawServer(t, 200, `{"error":{"code":-32000,"message":"header not found"}}`),
+			wantCode: -32000, wantMsg: "header not found"},
+		{name: "http error", url: rawServer(t, 503, ``), wantMsg: "HTTP 503"},
+		{name: "non-hex block number", url: rawServer(t, 200, `{"result":12}`), wantMsg: "invalid block number 12"},
+		{name: "unreachable", url: deadURL(t)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, ms, head, fail := checkPing(tt.url)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v (fail %+v)", ok, tt.wantOK, fail)
+			}
+			if ok {
+				if fail != nil {
+					t.Errorf("fail = %+v, want nil", fail)
+				}
+				if ms < tt.minMs || ms > 5_000 {
+					t.Errorf("ms = %v, want in [%v, 5000]", ms, tt.minMs)
+				}
+				if head != healthy.Head {
+					t.Errorf("head = %d, want %d", head, healthy.Head)
+				}
+				return
+			}
+			if fail == nil {
+				t.Fatal("fail = nil for failed ping")
+			}
+			if fail.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", fail.Code, tt.wantCode)
+			}
+			if tt.wantMsg != "" && fail.Message != tt.wantMsg {
+				t.Errorf("message = %q, want %q", fail.Message, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestCheckArchive(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		wantOK   bool
+		wantLogs int
+		wantCode int
+		wantMsg  string
+	}{
+		{name: "logs present", url: mockServer(t, healthy, 0), wantOK: true, wantLogs: 3},
+		{name: "zero logs", url: mockServer(t, mockNode{Head: 100_000}, 0),
+			wantMsg: "0 logs at deploy block (silent drop)"},
+		{name: "result not an array", url: rawServer(t, 200, `{"result":{"oops":true}}`),
+			wantMsg: "invalid result"},
+		{name: "rpc error", url: rawServer(t, 200, `{"error":{"code":-32005,"message":"range exceeded"}}`),
+			wantCode: -32005, wantMsg: "range exceeded"},
+		{name: "malformed body", url: rawServer(t, 200, `<html>`)},
+		{name: "unreachable", url: deadURL(t)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, n, fail := checkArchive(tt.url, testDeploy)
+			if ok != tt.wantOK || n != tt.wantLogs {
+				t.Fatalf("ok, n = %v, %d; want %v, %d (fail %+v)", ok, n, tt.wantOK, tt.wantLogs, fail)
+			}
+			if ok {
+				return
+			}
+			if fail == nil {
+				t.Fatal("fail = nil for failed archive check")
+			}
+			if fail.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", fail.Code, tt.wantCode)
+			}
+			if tt.wantMsg != "" && fail.Message != tt.wantMsg {
+				t.Errorf("message = %q, want %q", fail.Message, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestLatencyJitter(t *testing.T) {
+	tests := []struct {
+		samples []float64
+		want    float64
+	}{
+		{nil, 0},
+		{[]float64{50}, 0},
+		{[]float64{50, 50, 50}, 0},
+		{[]float64{10, 30}, 10},
+		{[]float64{10, -1, 30, -1}, 10},
+		{[]float64{2, 4, 4, 4, 5, 5, 7, 9}, 2},
+	}
+	for _, tt := range tests {
+		if got := latencyJitter(tt.samples); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("latencyJitter(%v) = %v, want %v", tt.samples, got, tt.want)
+		}
+	}
+}
+
+func TestCheckMaxRange(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want int
+	}{
+		{"unlimited", mockServer(t, healthy, 0), 50_000},
+		{"capped at 10k", mockServer(t, mockNode{Logs: 1, MaxRange: 10_000}, 0), 10_000},
+		{"capped between steps", mockServer(t, mockNode{Logs: 1, MaxRange: 4_999}, 0), 2_000},
+		{"below first step", mockServer(t, mockNode{Logs: 1, MaxRange: 499}, 0), 0},
+		{"rpc error", rawServer(t, 200, `{"error":{"code":-32603,"message":"internal"}}`), 0},
+		{"unreachable", deadURL(t), 0},
+	}
+	t.Cleanup(func() { opts.maxParallelProbes = 0 })
+	for _, parallel := range []int{1, 3} {
+		opts.maxParallelProbes = parallel
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/parallel=%d", tt.name, parallel), func(t *testing.T) {
+				if got := checkMaxRange(tt.url, testDeploy); got != tt.want {
+					t.Errorf("checkMaxRange = %d, want %d", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestCheckFeeMarket(t *testing.T) {
+	block := func(baseFee string) string {
+		return `{"result":{"number":"0x1","baseFeePerGas":"` + baseFee + `"}}`
+	}
+	tests := []struct {
+		name     string
+		url      string
+		multiple uint64
+		ok       bool
+	}{
+		{"covers base fee", rawServer(t, 200, block("0x64")), 1, true},
+		{"equals base fee", rawServer(t, 200, block("0xc8")), 1, true},
+		{"unset multiple means 1", rawServer(t, 200, block("0x64")), 0, true},
+		{"below base fee", rawServer(t, 200, block("0x3e8")), 1, false},
+		{"below multiple of base fee", rawServer(t, 200, block("0x64")), 3, false},
+		{"no base fee", rawServer(t, 200, `{"result":{"number":"0x1"}}`), 1, false},
+		{"null block", rawServer(t, 200, `{"result":null}`), 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := chainMeta{IsEIP1559: true, MinGasLimit: tt.multiple}
+			fail := checkFeeMarket(tt.url, meta, big.NewInt(200))
+			if (fail == nil) != tt.ok {
+				t.Errorf("checkFeeMarket = %v, want ok %v", fail, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCheckTxReceipt(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"with logs", `{"result":{"status":"0x1","logs":[{"address":"0x1"}]}}`, true},
+		{"no logs emitted", `{"result":{"status":"0x1","logs":[]}}`, true},
+		{"pruned", `{"result":null}`, false},
+		{"logs missing", `{"result":{"status":"0x1"}}`, false},
+		{"rpc error", `{"error":{"code":-32601,"message":"method not found"}}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkTxReceipt(rawServer(t, 200, tt.body), "0xabc"); got != tt.want {
+				t.Errorf("checkTxReceipt = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckGetCode(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"bytecode", `{"result":"0x6080604052"}`, true},
+		{"no code", `{"result":"0x"}`, false},
+		{"missing state", `{"error":{"code":-32000,"message":"missing trie node"}}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkGetCode(rawServer(t, 200, tt.body), testDeploy); got != tt.want {
+				t.Errorf("checkGetCode = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckMaxLogs(t *testing.T) {
+	// logsServer returns one identity log per block; reply decides the
+	// response for a query over n blocks.
+	logsServer := func(reply func(n int) string) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				Params []map[string]string `json:"params"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			f := req.Params[0]
+			if f["address"] != identityAddr {
+				t.Errorf("filter address = %q", f["address"])
+			}
+			from, _ := strconv.ParseUint(f["fromBlock"][2:], 16, 64)
+			to, _ := strconv.ParseUint(f["toBlock"][2:], 16, 64)
+			w.Write([]byte(reply(int(to - from + 1))))
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+	logs := func(n int) string {
+		return `{"result":[` + strings.TrimSuffix(strings.Repeat(`{},`, n), ",") + `]}`
+	}
+	tests := []struct {
+		name  string
+		reply func(n int) string
+		want  int
+	}{
+		{"named cap", func(n int) string {
+			if n > 700 {
+				return `{"error":{"code":-32005,"message":"query returned more than 700 results"}}`
+			}
+			return logs(n)
+		}, 700},
+		{"truncated plateau", func(n int) string { return logs(min(n, 1000)) }, 1000},
+		{"unnamed size error", func(n int) string {
+			if n > 1500 {
+				return `{"error":{"code":-32000,"message":"Log response size exceeded"}}`
+			}
+			return logs(n)
+		}, 1500},
+		{"block range error", func(n int) string {
+			if n > 1500 {
+				return `{"error":{"code":-32000,"message":"block range too wide"}}`
+			}
+			return logs(n)
+		}, 0},
+		{"no cap", logs, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkMaxLogs(logsServer(tt.reply), testDeploy); got != tt.want {
+				t.Errorf("checkMaxLogs = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckBlockByHash(t *testing.T) {
+	// byHash is the eth_getBlockByHash result; eth_getBlockByNumber always
+	// returns block 0x3e8 (testDeploy) with hash 0xaa.
+	server := func(byHash string) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req rpcReq
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Method == "eth_getBlockByHash" {
+				w.Write([]byte(`{"result":` + byHash + `}`))
+				return
+			}
+			w.Write([]byte(`{"result":{"number":"0x3e8","hash":"0xaa"}}`))
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+	tests := []struct {
+		name   string
+		byHash string
+		want   bool
+	}{
+		{"consistent", `{"number":"0x3e8","hash":"0xAA"}`, true},
+		{"other block", `{"number":"0x3e9","hash":"0xaa"}`, false},
+		{"missing", `null`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkBlockByHash(server(tt.byHash), testDeploy); got != tt.want {
+				t.Errorf("checkBlockByHash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckEngine(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{"method not found", rawServer(t, 200, `{"error":{"code":-32601,"message":"the method engine_forkchoiceUpdatedV1 does not exist"}}`), false},
+		{"unauthorized", rawServer(t, 200, `{"error":{"code":-32001,"message":"unauthorized"}}`), false},
+		{"http 401", rawServer(t, 401, `missing token`), false},
+		{"invalid params", rawServer(t, 200, `{"error":{"code":-32602,"message":"missing value for required argument 0"}}`), true},
+		{"answered", rawServer(t, 200, `{"result":{"payloadStatus":{"status":"SYNCING"}}}`), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkEngine(tt.url); got != tt.want {
+				t.Errorf("checkEngine = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTestEndpoint(t *testing.T) {
+	tests := []struct {
+		name          string
+		url           string
+		wantReachable bool
+		wantArchive   bool
+		wantRange     int
+		wantError     string
+		wantWarning   string
+	}{
+		{name: "archive", url: mockServer(t, mockNode{ChainID: 1, Logs: 2, MaxRange: 5_000}, 0),
+			wantReachable: true, wantArchive: true, wantRange: 5_000},
+		{name: "silent drop", url: mockServer(t, mockNode{ChainID: 1}, 0),
+			wantReachable: true, wantError: "0 logs at deploy block (silent drop)"},
+		{name: "ping rpc error", url: rawServer(t, 200, `{"error":{"code":-32601,"message":"method not found"}}`),
+			wantError: "method not found"},
+		{name: "wrong chain", url: mockServer(t, mockNode{ChainID: 10, Logs: 1, MaxRange: 5_000}, 0),
+			wantReachable: true, wantArchive: true, wantRange: 5_000, wantWarning: "wrong chain: eth_chainId is 10"},
+		{name: "unreachable", url: deadURL(t)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := testEndpoint(tt.url, 1, chainMeta{DeployBlock: testDeploy})
+			if got.URL != tt.url {
+				t.Errorf("URL = %q, want %q", got.URL, tt.url)
+			}
+			if got.Reachable != tt.wantReachable || got.Archive != tt.wantArchive {
+				t.Fatalf("Reachable, Archive = %v, %v; want %v, %v (%+v)",
+					got.Reachable, got.Archive, tt.wantReachable, tt.wantArchive, got)
+			}
+			if got.MaxRange != tt.wantRange {
+				t.Errorf("MaxRange = %d, want %d", got.MaxRange, tt.wantRange)
+			}
+			if tt.wantError != "" && got.Error != tt.wantError {
+				t.Errorf("Error = %q, want %q", got.Error, tt.wantError)
+			}
+			if tt.wantWarning != "" && !slices.Contains(got.Warnings, tt.wantWarning) {
+				t.Errorf("Warnings = %q, want %q", got.Warnings, tt.wantWarning)
+			}
+			if !got.Reachable && got.Error == "" {
+				t.Error("unreachable endpoint without Error")
+			}
+			if got.BytesSent == 0 {
+				t.Error("BytesSent = 0, want traffic accounted")
+			}
+		})
+	}
+}
+
+func TestMockHandlerChainID(t *testing.T) {
+	srv := httptest.NewServer(mockHandler())
+	defer srv.Close()
+
+	r, _, err := rpcCall(srv.URL+"/8453", "eth_chainId", []any{})
+	if err != nil || r.Error != nil {
+		t.Fatalf("eth_chainId: %v %+v", err, r)
+	}
+	var got string
+	json.Unmarshal(r.Result, &got)
+	if got != toHex(8453) {
+		t.Errorf("chain ID = %s, want %s", got, toHex(8453))
+	}
+}
+
+func FuzzTruncate(f *testing.F) {
+	f.Add("method not found", 60)
+	f.Add("превышен лимит запросов", 7)
+	f.Add("日本語のエラー", 4)
+	f.Add("emoji 🚀 overflow", 8)
+	f.Add("", 0)
+	f.Fuzz(func(t *testing.T, s string, n int) {
+		n = int(uint(n) % 256)
+		got := truncate(s, n)
+		if len(got) > n {
+			t.Fatalf("truncate(%q, %d) = %q, longer than %d bytes", s, n, got, n)
+		}
+		if !strings.HasPrefix(s, got) {
+			t.Fatalf("truncate(%q, %d) = %q, not a prefix", s, n, got)
+		}
+		if utf8.ValidString(s) && !utf8.ValidString(got) {
+			t.Fatalf("truncate(%q, %d) = %q, invalid UTF-8", s, n, got)
+		}
+		if len(s) <= n && got != s {
+			t.Fatalf("truncate(%q, %d) = %q, want unchanged", s, n, got)
+		}
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Thresholds above which compare reports a latency or max range change, as
+// a fraction of the value in the first file.
+const (
+	compareLatencyFrac = 0.20
+	compareRangeFrac   = 0.10
+)
+
+// endpointChange is one difference between two results files.
+type endpointChange struct {
+	ChainID uint64 `json:"chain_id"`
+	URL     string `json:"url"`
+	Kind    string `json:"kind"` // only_a, only_b, status, latency or max_range
+	A       string `json:"a,omitempty"`
+	B       string `json:"b,omitempty"`
+}
+
+// compareFiles is the compare subcommand: it diffs two -output-json files
+// without touching the network.
+func compareFiles(args []string) {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	format := flags.String("format", "text", "output format: text, json or markdown")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: test_rpcs compare [-format text|json|markdown] a.json b.json")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	a, err := loadResults(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := loadResults(flags.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	changes := diffResultFiles(a, b)
+	switch *format {
+	case "text":
+		printChangesText(os.Stdout, changes)
+	case "json":
+		out, _ := json.MarshalIndent(changes, "", "  ")
+		fmt.Println(string(out))
+	case "markdown":
+		printChangesMarkdown(os.Stdout, changes)
+	default:
+		log.Fatalf("-format: unknown format %q (want text, json or markdown)", *format)
+	}
+}
+
+// diffResultFiles lists endpoints found in only one file, then for those in
+// both any change of status, of latency beyond compareLatencyFrac and of max
+// range beyond compareRangeFrac. Chains and URLs are in sorted order.
+func diffResultFiles(a, b resultsFile) []endpointChange {
+	changes := []endpointChange{}
+	ids := slices.Sorted(maps.Keys(a.Chains))
+	for cid := range b.Chains {
+		if _, ok := a.Chains[cid]; !ok {
+			ids = append(ids, cid)
+		}
+	}
+	slices.Sort(ids)
+	for _, cid := range ids {
+		ra, rb := byURL(a.Chains[cid]), byURL(b.Chains[cid])
+		urls := slices.Sorted(maps.Keys(ra))
+		for u := range rb {
+			if _, ok := ra[u]; !ok {
+				urls = append(urls, u)
+			}
+		}
+		slices.Sort(urls)
+		for _, u := range urls {
+			x, inA := ra[u]
+			y, inB := rb[u]
+			add := func(kind, before, after string) {
+				changes = append(changes, endpointChange{cid, u, kind, before, after})
+			}
+			switch {
+			case !inB:
+				add("only_a", endpointStatus(x), "")
+				continue
+			case !inA:
+				add("only_b", "", endpointStatus(y))
+				continue
+			}
+			if sa, sb := endpointStatus(x), endpointStatus(y); sa != sb {
+				add("status", sa, sb)
+			}
+			if !x.Reachable || !y.Reachable {
+				continue
+			}
+			if changedBy(x.LatencyMs, y.LatencyMs, compareLatencyFrac) {
+				add("latency", fmt.Sprintf("%.0fms", x.LatencyMs), fmt.Sprintf("%.0fms", y.LatencyMs))
+			}
+			if changedBy(float64(x.MaxRange), float64(y.MaxRange), compareRangeFrac) {
+				add("max_range", fmtInt(x.MaxRange), fmtInt(y.MaxRange))
+			}
+		}
+	}
+	return changes
+}
+
+func byURL(results []result) map[string]result {
+	m := make(map[string]result, len(results))
+	for _, r := range results {
+		m[r.URL] = r
+	}
+	return m
+}
+
+// endpointStatus is "unreachable", "archive" or "non-archive".
+func endpointStatus(r result) string {
+	switch {
+	case !r.Reachable:
+		return "unreachable"
+	case r.Archive:
+		return "archive"
+	default:
+		return "non-archive"
+	}
+}
+
+// changedBy reports whether b differs from a by more than frac of a. Any
+// change from zero counts.
+func changedBy(a, b, frac float64) bool {
+	if a == 0 {
+		return b != 0
+	}
+	return math.Abs(b-a)/a > frac
+}
+
+// changeText describes a change for the text and Markdown reports.
+func changeText(c endpointChange) string {
+	switch c.Kind {
+	case "only_a":
+		return "only in A (" + c.A + ")"
+	case "only_b":
+		return "only in B (" + c.B + ")"
+	default:
+		return fmt.Sprintf("%s %s → %s", strings.ReplaceAll(c.Kind, "_", " "), c.A, c.B)
+	}
+}
+
+func printChangesText(w io.Writer, changes []endpointChange) {
+	for _, c := range changes {
+		fmt.Fprintf(w, "  [%6d] %s: %s\n", c.ChainID, c.URL, changeText(c))
+	}
+	fmt.Fprintf(w, "  %d differences\n", len(changes))
+}
+
+// pipe escapes the cell separator inside Markdown table cells.
+var pipe = strings.NewReplacer("|", `\|`)
+
+func printChangesMarkdown(w io.Writer, changes []endpointChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No differences.")
+		return
+	}
+	fmt.Fprintln(w, "| Chain | Endpoint | Change |")
+	fmt.Fprintln(w, "|---|---|---|")
+	for _, c := range changes {
+		name := cmp.Or(chains[c.ChainID].Name, strconv.FormatUint(c.ChainID, 10))
+		fmt.Fprintf(w, "| %s | `%s` | %s |\n", name, pipe.Replace(c.URL), pipe.Replace(changeText(c)))
+	}
+}
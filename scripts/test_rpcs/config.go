@@ -1,42 +1,354 @@
 package main
 
 import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
 )
 
 type chainMeta struct {
 	Name        string
 	DeployBlock uint64
+	ZeroGas     bool // eth_gasPrice may legitimately return 0
+
+	// SampleUncleBlock is a block known to include an uncle, probed by
+	// -check-uncle. Zero for chains without uncles (post-merge Ethereum and
+	// the L2s), which skips the check.
+	SampleUncleBlock uint64
+
+	// SupportsBlobFee marks chains that carry EIP-4844 blob transactions;
+	// -check-blob is skipped elsewhere.
+	SupportsBlobFee bool
+
+	// IsEIP1559 marks chains whose blocks carry baseFeePerGas; -gas-price-check
+	// then requires eth_gasPrice to reach MinGasLimit times the latest base
+	// fee. MinGasLimit is 1 on every EIP-1559 chain, the protocol floor (a
+	// transaction pays at least the base fee); zero also means 1.
+	IsEIP1559   bool
+	MinGasLimit uint64
+
+	// SampleTx is a historical transaction hash probed by -check-tx-by-hash.
+	// When empty, the first transaction of the deploy block is used.
+	SampleTx string
 }
 
 var chains = map[uint64]chainMeta{
-	1:      {"Ethereum", 24_339_871},
-	10:     {"Optimism", 147_514_947},
-	56:     {"BSC", 79_027_268},
-	100:    {"Gnosis", 44_505_010},
-	137:    {"Polygon", 82_458_484},
-	143:    {"Monad", 52_952_790},
-	2741:   {"Abstract", 39_596_871},
-	4326:   {"MegaETH", 7_833_805},
-	5000:   {"Mantle", 91_333_846},
-	8453:   {"Base", 41_663_783},
-	42161:  {"Arbitrum", 428_895_443},
-	42220:  {"Celo", 58_396_724},
-	43114:  {"Avalanche", 77_389_000},
-	59144:  {"Linea", 28_662_553},
-	167000: {"Taiko", 4_305_747},
-	534352: {"Scroll", 29_432_417},
+	1:      {Name: "Ethereum", DeployBlock: 24_339_871, SupportsBlobFee: true, IsEIP1559: true, MinGasLimit: 1},
+	10:     {Name: "Optimism", DeployBlock: 147_514_947, IsEIP1559: true, MinGasLimit: 1},
+	56:     {Name: "BSC", DeployBlock: 79_027_268, SupportsBlobFee: true},
+	100:    {Name: "Gnosis", DeployBlock: 44_505_010, SupportsBlobFee: true, IsEIP1559: true, MinGasLimit: 1},
+	137:    {Name: "Polygon", DeployBlock: 82_458_484, IsEIP1559: true, MinGasLimit: 1},
+	143:    {Name: "Monad", DeployBlock: 52_952_790, IsEIP1559: true, MinGasLimit: 1},
+	2741:   {Name: "Abstract", DeployBlock: 39_596_871, IsEIP1559: true, MinGasLimit: 1},
+	4326:   {Name: "MegaETH", DeployBlock: 7_833_805, IsEIP1559: true, MinGasLimit: 1},
+	5000:   {Name: "Mantle", DeployBlock: 91_333_846, IsEIP1559: true, MinGasLimit: 1},
+	8453:   {Name: "Base", DeployBlock: 41_663_783, IsEIP1559: true, MinGasLimit: 1},
+	42161:  {Name: "Arbitrum", DeployBlock: 428_895_443, IsEIP1559: true, MinGasLimit: 1},
+	42220:  {Name: "Celo", DeployBlock: 58_396_724, IsEIP1559: true, MinGasLimit: 1},
+	43114:  {Name: "Avalanche", DeployBlock: 77_389_000, IsEIP1559: true, MinGasLimit: 1},
+	59144:  {Name: "Linea", DeployBlock: 28_662_553, IsEIP1559: true, MinGasLimit: 1},
+	167000: {Name: "Taiko", DeployBlock: 4_305_747, IsEIP1559: true, MinGasLimit: 1},
+	534352: {Name: "Scroll", DeployBlock: 29_432_417, IsEIP1559: true, MinGasLimit: 1},
+}
+
+// chainID resolves a chain given by decimal ID or, case-insensitively, by
+// name in the chains map.
+func chainID(s string) (uint64, bool) {
+	s = strings.TrimSpace(s)
+	if id, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return id, id > 0
+	}
+	for id, m := range chains {
+		if strings.EqualFold(m.Name, s) {
+			return id, true
+		}
+	}
+	return 0, false
 }
 
 const identityAddr = "0x8004A169FB4a3325136EB29fA0ceB6D2e539a432"
 
 type config struct {
+	// ChainMetaURL points to a JSON object of chain ID to chainMeta (keyed by
+	// its Go field names) fetched at startup. Its entries replace compiled-in
+	// ones; [chain_meta] sections replace its entries in turn.
+	ChainMetaURL string `toml:"chain_meta_url,omitempty"`
+
 	Chains map[string]chainCfg `toml:"chains"`
+
+	// ChainMeta describes chains missing from the compiled-in chains map.
+	ChainMeta map[string]chainMetaCfg `toml:"chain_meta,omitempty"`
+}
+
+// chainMetaCfg is a [chain_meta.<id>] section.
+type chainMetaCfg struct {
+	Name            string `toml:"name"`
+	DeployBlock     uint64 `toml:"deploy_block"`
+	ZeroGas         bool   `toml:"zero_gas,omitempty"`
+	SupportsBlobFee bool   `toml:"supports_blob_fee,omitempty"`
+}
+
+// loadConfig decodes the config file and registers its [chain_meta] chains.
+func loadConfig(path string) (config, error) {
+	var cfg config
+	if _, err := toml.DecodeFile(path, &cfg); err != nil {
+		return cfg, err
+	}
+	var remote map[uint64]bool
+	if cfg.ChainMetaURL != "" {
+		var err error
+		if remote, err = mergeRemoteChainMeta(cfg.ChainMetaURL); err != nil {
+			return cfg, fmt.Errorf("chain_meta_url: %w", err)
+		}
+	}
+	cfg.addChainMeta(remote)
+	return cfg, nil
+}
+
+// loadConfigs loads each of paths with loadConfig and merges them in order.
+// Under the "append" strategy a later file's rpcs for a chain follow the
+// earlier ones, skipping duplicates; under "replace" they replace them.
+// Other chain settings and [chain_meta] sections from later files win.
+func loadConfigs(paths []string, strategy string) (config, error) {
+	var merged config
+	for i, path := range paths {
+		cfg, err := loadConfig(path)
+		if err != nil {
+			return merged, fmt.Errorf("%s: %w", path, err)
+		}
+		if i == 0 {
+			merged = cfg
+			continue
+		}
+		merged.ChainMetaURL = cmp.Or(cfg.ChainMetaURL, merged.ChainMetaURL)
+		for id, m := range cfg.ChainMeta {
+			if merged.ChainMeta == nil {
+				merged.ChainMeta = map[string]chainMetaCfg{}
+			}
+			merged.ChainMeta[id] = m
+		}
+		for id, cc := range cfg.Chains {
+			prev, ok := merged.Chains[id]
+			if ok && strategy == "append" {
+				cc = prev.appendRPCs(cc)
+			}
+			if merged.Chains == nil {
+				merged.Chains = map[string]chainCfg{}
+			}
+			merged.Chains[id] = cc
+		}
+	}
+	return merged, nil
+}
+
+// appendRPCs returns c with next's new rpcs appended and next's non-zero
+// settings taking over. Weights survive only if both sides have one per RPC.
+func (c chainCfg) appendRPCs(next chainCfg) chainCfg {
+	weighted := len(c.Weights) == len(c.RPCs) && len(next.Weights) == len(next.RPCs) && len(next.RPCs) > 0
+	out := chainCfg{
+		RPCs:                 slices.Clone(c.RPCs),
+		CheckIntervalSeconds: cmp.Or(next.CheckIntervalSeconds, c.CheckIntervalSeconds),
+		DeployBlock:          cmp.Or(next.DeployBlock, c.DeployBlock),
+	}
+	if weighted {
+		out.Weights = slices.Clone(c.Weights)
+	}
+	for i, u := range next.RPCs {
+		if slices.Contains(out.RPCs, u) {
+			continue
+		}
+		out.RPCs = append(out.RPCs, u)
+		if weighted {
+			out.Weights = append(out.Weights, next.Weights[i])
+		}
+	}
+	return out
+}
+
+// mergeRemoteChainMeta fetches chain metadata from url into the chains map,
+// replacing compiled-in entries, and returns the chain IDs it set.
+func mergeRemoteChainMeta(url string) (map[uint64]bool, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	var metas map[uint64]chainMeta
+	if err := json.NewDecoder(resp.Body).Decode(&metas); err != nil {
+		return nil, err
+	}
+	set := map[uint64]bool{}
+	for cid, m := range metas {
+		if cid == 0 || m.Name == "" {
+			return nil, fmt.Errorf("chain %d: a positive chain ID and a Name are required", cid)
+		}
+		chains[cid] = m
+		set[cid] = true
+	}
+	return set, nil
+}
+
+// addChainMeta registers the config's [chain_meta] chains. Compiled-in
+// chains take precedence unless remote, the chains fetched from
+// chain_meta_url, replaced them.
+func (c config) addChainMeta(remote map[uint64]bool) {
+	for id, m := range c.ChainMeta {
+		cid, err := strconv.ParseUint(id, 10, 64)
+		if _, known := chains[cid]; err != nil || (known && !remote[cid]) {
+			continue
+		}
+		chains[cid] = chainMeta{Name: m.Name, DeployBlock: m.DeployBlock, ZeroGas: m.ZeroGas, SupportsBlobFee: m.SupportsBlobFee}
+	}
+}
+
+// headerTOML renders the config's top-level keys, which TOML requires before
+// the first table, so rewriting the config keeps them.
+func (c config) headerTOML() string {
+	if c.ChainMetaURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("chain_meta_url = %q\n\n", c.ChainMetaURL)
+}
+
+// chainMetaTOML renders the [chain_meta] sections so rewriting the config
+// keeps them.
+func (c config) chainMetaTOML() string {
+	var b strings.Builder
+	for _, id := range slices.Sorted(maps.Keys(c.ChainMeta)) {
+		m := c.ChainMeta[id]
+		fmt.Fprintf(&b, "[chain_meta.%s]\nname = %q\ndeploy_block = %d\n", id, m.Name, m.DeployBlock)
+		if m.ZeroGas {
+			b.WriteString("zero_gas = true\n")
+		}
+		if m.SupportsBlobFee {
+			b.WriteString("supports_blob_fee = true\n")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
 }
 
 type chainCfg struct {
 	RPCs []string `toml:"rpcs"`
+
+	// CheckIntervalSeconds overrides the -watch interval for this chain.
+	CheckIntervalSeconds int `toml:"check_interval_seconds,omitempty"`
+
+	// DeployBlock overrides chainMeta.DeployBlock as the start of the log
+	// filters, e.g. to probe history from before the deployment.
+	DeployBlock uint64 `toml:"deploy_block,omitempty"`
+
+	// Weights are load-balancer weights parallel to RPCs, used by
+	// -format nginx. Without them weights derive from the test scores.
+	Weights []int `toml:"weights,omitempty"`
+}
+
+// settingsTOML renders the chain's non-RPC settings as TOML key/value lines so
+// that rewriting the config preserves them.
+func (c chainCfg) settingsTOML() string {
+	var b strings.Builder
+	if c.CheckIntervalSeconds > 0 {
+		fmt.Fprintf(&b, "check_interval_seconds = %d\n", c.CheckIntervalSeconds)
+	}
+	if c.DeployBlock > 0 {
+		fmt.Fprintf(&b, "deploy_block = %d\n", c.DeployBlock)
+	}
+	return b.String()
+}
+
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv substitutes ${VAR} references in an RPC URL from the environment
+// and reports the referenced variables that are unset or empty. Config URLs
+// keep their placeholders; only the requests see the expanded form.
+func expandEnv(u string) (string, []string) {
+	var missing []string
+	out := envRef.ReplaceAllStringFunc(u, func(m string) string {
+		name := m[2 : len(m)-1]
+		v := os.Getenv(name)
+		if v == "" {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	return out, missing
+}
+
+// envURLs maps each expanded RPC URL, with and without its userinfo, to the
+// config form it came from.
+var envURLs sync.Map
+
+// expandURL is expandEnv for an RPC URL about to be requested. It remembers
+// the config form so redactEnv can keep substituted secrets out of logs,
+// traces and error text.
+func expandURL(u string) string {
+	expanded, _ := expandEnv(u)
+	if expanded != u {
+		envURLs.Store(expanded, u)
+		envURLs.Store(stripUserinfo(expanded), stripUserinfo(u))
+	}
+	return expanded
+}
+
+// redactEnv replaces every URL expanded by expandURL in s with its config
+// form.
+func redactEnv(s string) string {
+	envURLs.Range(func(k, v any) bool {
+		s = strings.ReplaceAll(s, k.(string), v.(string))
+		return true
+	})
+	return s
+}
+
+// displayURL is the form of an RPC URL fit for logs: credentials stripped and
+// ${VAR} placeholders restored.
+func displayURL(u string) string { return redactEnv(stripUserinfo(u)) }
+
+// redactedError is an error whose text has passed through redactEnv. It
+// still unwraps to the original.
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// redactErr wraps err when its text contains an expanded URL.
+func redactErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if msg := redactEnv(err.Error()); msg != err.Error() {
+		return &redactedError{err, msg}
+	}
+	return err
+}
+
+// warnUnsetEnv logs every ${VAR} in the config that would expand to nothing.
+func warnUnsetEnv(cfg config) {
+	for _, cid := range slices.Sorted(maps.Keys(cfg.Chains)) {
+		for _, u := range cfg.Chains[cid].RPCs {
+			if _, missing := expandEnv(u); len(missing) > 0 {
+				log.Printf("warning: chain %s: %s unset or empty in %s", cid, strings.Join(missing, ", "), stripUserinfo(u))
+			}
+		}
+	}
 }
 
 func findConfig(name string) string {
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// tempTree creates dir/a/b under a fresh temp dir and returns the root.
+func tempTree(t *testing.T) string {
+	t.Helper()
+	root, err := os.MkdirTemp("", "findconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	root, _ = filepath.EvalSymlinks(root)
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func touch(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindConfigInCwd(t *testing.T) {
+	root := tempTree(t)
+	want := filepath.Join(root, "a", "b", "config.toml")
+	touch(t, want)
+	touch(t, filepath.Join(root, "config.toml"))
+	t.Chdir(filepath.Join(root, "a", "b"))
+
+	if got := findConfig("config.toml"); got != want {
+		t.Errorf("findConfig = %q, want %q", got, want)
+	}
+}
+
+func TestFindConfigInAncestor(t *testing.T) {
+	root := tempTree(t)
+	want := filepath.Join(root, "config.toml")
+	touch(t, want)
+	t.Chdir(filepath.Join(root, "a", "b"))
+
+	if got := findConfig("config.toml"); got != want {
+		t.Errorf("findConfig = %q, want %q", got, want)
+	}
+}
+
+func TestFindConfigNotFound(t *testing.T) {
+	root := tempTree(t)
+	t.Chdir(filepath.Join(root, "a", "b"))
+
+	const name = "test-rpcs-missing-config.toml"
+	if got := findConfig(name); got != name {
+		t.Errorf("findConfig = %q, want bare %q", got, name)
+	}
+}
+
+func TestFindConfigAtRoot(t *testing.T) {
+	root := filepath.VolumeName(os.TempDir()) + string(filepath.Separator)
+	t.Chdir(root)
+
+	const name = "test-rpcs-missing-config.toml"
+	if got := findConfig(name); got != name {
+		t.Errorf("findConfig = %q, want bare %q", got, name)
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	src := `[chains.1]
+rpcs = [
+    "https://a.example.com",
+    "ftp://b.example.com",
+    "https://a.example.com",
+]
+
+[chains.999]
+rpcs = ["https://${KEY}.example.com/v1"]
+
+[chains.8453]
+rpcs = ["https://a.example.com"]
+weights = [0, 2]
+`
+	want := []lintProblem{
+		{4, `chains.1: ftp://b.example.com: scheme "ftp" is not http, https, ws or wss`},
+		{5, "chains.1: https://a.example.com duplicates chains.1 line 3"},
+		{8, "chains.999: unknown chain needs a [chain_meta.999] section with a name and deploy_block"},
+		{12, "chains.8453: https://a.example.com duplicates chains.1 line 3"},
+		{13, "chains.8453: 2 weights for 1 rpcs"},
+		{13, "chains.8453: weights must be positive"},
+	}
+	got := lintConfig(src)
+	if !slices.Equal(got, want) {
+		t.Errorf("lintConfig:\n got %v\nwant %v", got, want)
+	}
+
+	if got := lintConfig(src[:len(src)-1] + "\n[chain_meta.999]\nname = \"Test\"\ndeploy_block = 1\n"); len(got) != 5 {
+		t.Errorf("with chain_meta: got %d problems, want 5: %v", len(got), got)
+	}
+	if got := lintConfig(src[:len(src)-1] + "\n[chain_meta.999]\nname = \"Test\"\ndeploy_block = 0\n"); len(got) != 5 {
+		t.Errorf("with deploy_block = 0: got %d problems, want 5: %v", len(got), got)
+	}
+	if got := lintConfig(src[:len(src)-1] + "\n[chain_meta.999]\nname = \"Test\"\n"); len(got) != 6 {
+		t.Errorf("without deploy_block: got %d problems, want 6: %v", len(got), got)
+	}
+}
+
+func TestLoadConfigRemoteChainMeta(t *testing.T) {
+	saved := maps.Clone(chains)
+	t.Cleanup(func() { chains = saved })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"1": {"Name": "Mainnet", "DeployBlock": 5}, "998": {"Name": "Remote", "DeployBlock": 7}, "999": {"Name": "Remote", "DeployBlock": 8}}`))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	src := "chain_meta_url = \"" + srv.URL + "\"\n\n[chain_meta.999]\nname = \"Local\"\ndeploy_block = 9\n"
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	for cid, want := range map[uint64]string{1: "Mainnet", 998: "Remote", 999: "Local"} {
+		if got := chains[cid].Name; got != want {
+			t.Errorf("chains[%d].Name = %q, want %q", cid, got, want)
+		}
+	}
+}
+
+func TestLoadConfigsMerge(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	prod := write("prod.toml", "[chains.1]\nrpcs = [\"https://a\", \"https://b\"]\nweights = [2, 1]\n\n[chains.10]\nrpcs = [\"https://op\"]\n")
+	staging := write("staging.toml", "[chains.1]\ndeploy_block = 5\nrpcs = [\"https://b\", \"https://c\"]\nweights = [1, 3]\n")
+
+	tests := []struct {
+		strategy string
+		want     chainCfg
+	}{
+		{"append", chainCfg{RPCs: []string{"https://a", "https://b", "https://c"}, DeployBlock: 5, Weights: []int{2, 1, 3}}},
+		{"replace", chainCfg{RPCs: []string{"https://b", "https://c"}, DeployBlock: 5, Weights: []int{1, 3}}},
+	}
+	for _, tt := range tests {
+		cfg, err := loadConfigs([]string{prod, staging}, tt.strategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := cfg.Chains["1"]
+		if !slices.Equal(got.RPCs, tt.want.RPCs) || !slices.Equal(got.Weights, tt.want.Weights) || got.DeployBlock != tt.want.DeployBlock {
+			t.Errorf("%s: chains.1 = %+v, want %+v", tt.strategy, got, tt.want)
+		}
+		if rpcs := cfg.Chains["10"].RPCs; !slices.Equal(rpcs, []string{"https://op"}) {
+			t.Errorf("%s: chains.10 rpcs = %v", tt.strategy, rpcs)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// resultColumn maps a result field to a column of the results table, named
+// after its JSON key.
+type resultColumn struct {
+	name, sqlType string
+	index         int
+}
+
+// baseColumns are the results table's own columns, written by saveResults.
+var baseColumns = []string{"run_id", "timestamp", "chain_id"}
+
+// resultColumns derives the results table's columns from the result struct
+// so new fields are stored without touching this file. Fields that are not
+// plain scalars are stored as JSON text. A JSON key that collides with a base
+// column, such as the endpoint-reported chain_id, is stored as reported_<key>.
+func resultColumns() []resultColumn {
+	t := reflect.TypeFor[result]()
+	var cols []resultColumn
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		if slices.Contains(baseColumns, name) {
+			name = "reported_" + name
+		}
+		typ := "TEXT"
+		switch f.Type.Kind() {
+		case reflect.Bool, reflect.Int, reflect.Int64, reflect.Uint64:
+			typ = "INTEGER"
+		case reflect.Float64:
+			typ = "REAL"
+		}
+		cols = append(cols, resultColumn{name, typ, i})
+	}
+	return cols
+}
+
+// openResultsDB opens (creating if needed) the -db database and adds any
+// result columns missing from an older schema.
+func openResultsDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS results (
+		run_id    INTEGER NOT NULL,
+		timestamp TEXT    NOT NULL,
+		chain_id  INTEGER NOT NULL
+	)`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	have := map[string]bool{}
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('results')`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	for rows.Next() {
+		var n string
+		rows.Scan(&n)
+		have[n] = true
+	}
+	rows.Close()
+	for _, c := range resultColumns() {
+		if have[c.name] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE results ADD COLUMN %q %s`, c.name, c.sqlType)); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// saveResults appends one run to the -db database.
+func saveResults(path string, at time.Time, allResults map[uint64][]result) error {
+	db, err := openResultsDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	cols := resultColumns()
+	names := slices.Clone(baseColumns)
+	for _, c := range cols {
+		names = append(names, fmt.Sprintf("%q", c.name))
+	}
+	insert := fmt.Sprintf("INSERT INTO results (%s) VALUES (%s)",
+		strings.Join(names, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", "))
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var runID int64
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(run_id), 0) + 1 FROM results`).Scan(&runID); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(insert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	ts := at.UTC().Format(time.RFC3339)
+	for _, cid := range slices.Sorted(maps.Keys(allResults)) {
+		for _, r := range allResults[cid] {
+			v := reflect.ValueOf(r)
+			args := []any{runID, ts, cid}
+			for _, c := range cols {
+				args = append(args, sqlValue(v.Field(c.index)))
+			}
+			if _, err := stmt.Exec(args...); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func sqlValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int64, reflect.Uint64, reflect.Float64, reflect.String:
+		return v.Interface()
+	}
+	if v.IsZero() {
+		return nil
+	}
+	data, _ := json.Marshal(v.Interface())
+	return string(data)
+}
+
+// queryResultsDB runs an ad-hoc -db-query and prints the rows as a table.
+func queryResultsDB(w io.Writer, path, query string) error {
+	db, err := openResultsDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(cols, "\t"))
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		cells := make([]string, len(vals))
+		for i, v := range vals {
+			switch v := v.(type) {
+			case nil:
+				cells[i] = "NULL"
+			case []byte:
+				cells[i] = string(v)
+			default:
+				cells[i] = fmt.Sprint(v)
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
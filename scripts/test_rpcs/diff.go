@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// loadResults reads an -output-json file.
+func loadResults(path string) (resultsFile, error) {
+	var f resultsFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return f, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// diffResults writes to w how each endpoint's status changed since prev and
+// marks endpoints whose archive status flipped as Unstable.
+func diffResults(w io.Writer, prev resultsFile, cur map[uint64][]result) {
+	fmt.Fprintf(w, "\n%s\n  CHANGES since %s\n%s\n",
+		strings.Repeat("─", 90), prev.GeneratedAt.Format("2006-01-02 15:04 UTC"), strings.Repeat("─", 90))
+	changed, unstable := 0, 0
+	for _, cid := range slices.Sorted(maps.Keys(cur)) {
+		old := map[string]result{}
+		for _, r := range prev.Chains[cid] {
+			old[r.URL] = r
+		}
+		for i := range cur[cid] {
+			r := &cur[cid][i]
+			p, ok := old[r.URL]
+			delete(old, r.URL)
+			var what string
+			switch {
+			case !ok:
+				what = "new endpoint"
+			case p.Archive != r.Archive:
+				r.Unstable = true
+				unstable++
+				what = fmt.Sprintf("archive %s → %s", yesNo(p.Archive), yesNo(r.Archive))
+			case p.Reachable != r.Reachable:
+				what = fmt.Sprintf("reachable %s → %s", yesNo(p.Reachable), yesNo(r.Reachable))
+			case p.MaxRange != r.MaxRange:
+				what = fmt.Sprintf("max range %s → %s", fmtInt(p.MaxRange), fmtInt(r.MaxRange))
+			default:
+				continue
+			}
+			changed++
+			mark := " "
+			if r.Unstable && opts.reportUnstable {
+				mark = "⚠"
+			}
+			fmt.Fprintf(w, "  %s [%6d] %s: %s\n", mark, cid, r.URL, what)
+		}
+		for _, u := range slices.Sorted(maps.Keys(old)) {
+			changed++
+			fmt.Fprintf(w, "    [%6d] %s: removed\n", cid, u)
+		}
+	}
+	fmt.Fprintf(w, "  %d changed", changed)
+	if opts.reportUnstable {
+		fmt.Fprintf(w, " · %d UNSTABLE (archive status flipped)", unstable)
+	}
+	fmt.Fprintln(w)
+}
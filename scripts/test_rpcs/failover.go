@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// simulateFailover walks a chain's RPCs in config order the way the sync
+// engine does, issuing the archive eth_getLogs query to each. Endpoints
+// before the first success are exhausted; the first success serves; the rest
+// are probed as fallbacks to show how much headroom remains.
+func simulateFailover(t target) {
+	fmt.Printf("\n%s\n  %s (chain %d) — failover simulation\n%s\n",
+		strings.Repeat("─", 90), t.meta.Name, t.cid, strings.Repeat("─", 90))
+	served := -1
+	healthy := 0
+	for i, u := range t.cfg.RPCs {
+		expanded := expandURL(u)
+		ok, _, fail := checkArchive(expanded, t.meta.DeployBlock)
+		role := "fallback"
+		switch {
+		case served < 0 && ok:
+			served, role = i, "serving"
+		case served < 0:
+			role = "exhausted"
+		case ok:
+			healthy++
+		}
+		status, note := "✓", ""
+		if !ok {
+			status, note = "✗", "  "+truncate(fail.Message, 60)
+		}
+		fmt.Printf(" %2d  %s  %-9s  %s%s\n", i+1, status, role, stripUserinfo(u), note)
+	}
+	switch {
+	case served < 0:
+		fmt.Printf("  ALL %d ENDPOINTS FAILED — the sync engine would stall\n", len(t.cfg.RPCs))
+	default:
+		fmt.Printf("  served after %d failed endpoint(s); %d/%d fallbacks healthy\n",
+			served, healthy, len(t.cfg.RPCs)-served-1)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// ipAPIBatch is ip-api.com's free batch endpoint (100 IPs per request).
+const ipAPIBatch = "http://ip-api.com/batch?fields=status,query,countryCode,as"
+
+type ipInfo struct {
+	Status      string `json:"status"`
+	Query       string `json:"query"`
+	CountryCode string `json:"countryCode"`
+	AS          string `json:"as"` // "AS16509 Amazon.com, Inc."
+}
+
+// annotateIPs resolves every endpoint's host and fills in IP, Country, ASN
+// and ASName from ip-api.com, then flags endpoints that share an ASN with
+// another endpoint of the same chain.
+func annotateIPs(allResults map[uint64][]result) error {
+	ips := map[string]string{} // host → IP
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, results := range allResults {
+		for _, r := range results {
+			host := endpointHost(r.URL)
+			mu.Lock()
+			_, seen := ips[host]
+			if !seen {
+				ips[host] = ""
+			}
+			mu.Unlock()
+			if host == "" || seen {
+				continue
+			}
+			wg.Go(func() {
+				addrs, err := net.DefaultResolver.LookupIP(context.Background(), "ip", host)
+				if err != nil || len(addrs) == 0 {
+					return
+				}
+				ip := addrs[0]
+				for _, a := range addrs {
+					if a.To4() != nil {
+						ip = a
+						break
+					}
+				}
+				mu.Lock()
+				ips[host] = ip.String()
+				mu.Unlock()
+			})
+		}
+	}
+	wg.Wait()
+
+	var queries []string
+	for _, ip := range ips {
+		if ip != "" {
+			queries = append(queries, ip)
+		}
+	}
+	info := map[string]ipInfo{}
+	for len(queries) > 0 {
+		n := min(len(queries), 100)
+		batch, err := lookupIPInfo(queries[:n])
+		if err != nil {
+			return err
+		}
+		for _, in := range batch {
+			if in.Status == "success" {
+				info[in.Query] = in
+			}
+		}
+		queries = queries[n:]
+	}
+
+	for _, results := range allResults {
+		asnCount := map[string]int{}
+		for i := range results {
+			r := &results[i]
+			r.IP = ips[endpointHost(r.URL)]
+			in := info[r.IP]
+			r.Country = in.CountryCode
+			r.ASN, r.ASName, _ = strings.Cut(in.AS, " ")
+			if r.ASN != "" {
+				asnCount[r.ASN]++
+			}
+		}
+		for i := range results {
+			results[i].SharedASN = asnCount[results[i].ASN] > 1
+		}
+	}
+	return nil
+}
+
+// printASNReport prints one row per autonomous system across all chains,
+// counting archive and non-archive endpoints, busiest first, to show how
+// much of the RPC list depends on a single provider.
+func printASNReport(w io.Writer, allResults map[uint64][]result) {
+	type asnRow struct {
+		asn, name           string
+		archive, nonArchive int
+		chains              map[uint64]bool
+	}
+	rows := map[string]*asnRow{}
+	total := 0
+	for cid, results := range allResults {
+		for _, r := range results {
+			asn, name := r.ASN, r.ASName
+			if asn == "" {
+				asn, name = "unknown", ""
+			}
+			row := rows[asn]
+			if row == nil {
+				row = &asnRow{asn: asn, name: name, chains: map[uint64]bool{}}
+				rows[asn] = row
+			}
+			if r.Archive {
+				row.archive++
+			} else {
+				row.nonArchive++
+			}
+			row.chains[cid] = true
+			total++
+		}
+	}
+	sorted := slices.SortedFunc(maps.Values(rows), func(a, b *asnRow) int {
+		return cmp.Or(
+			cmp.Compare(b.archive+b.nonArchive, a.archive+a.nonArchive),
+			cmp.Compare(a.asn, b.asn))
+	})
+
+	fmt.Fprintf(w, "\n%s\n  Endpoints by ASN — %d endpoints in %d networks\n%s\n",
+		strings.Repeat("─", 90), total, len(rows), strings.Repeat("─", 90))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, " ASN\tArchive\tNon-archive\tChains\tShare\tName")
+	for _, r := range sorted {
+		n := r.archive + r.nonArchive
+		fmt.Fprintf(tw, " %s\t%d\t%d\t%d\t%.0f%%\t%s\n",
+			r.asn, r.archive, r.nonArchive, len(r.chains), 100*float64(n)/float64(total), r.name)
+	}
+	tw.Flush()
+}
+
+func lookupIPInfo(ips []string) ([]ipInfo, error) {
+	body, _ := json.Marshal(ips)
+	resp, err := client.Post(ipAPIBatch, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ip-api: HTTP %d", resp.StatusCode)
+	}
+	var out []ipInfo
+	return out, json.NewDecoder(resp.Body).Decode(&out)
+}
+
+// endpointHost returns the hostname of an RPC URL after ${VAR} expansion.
+func endpointHost(rawURL string) string {
+	expanded, _ := expandEnv(rawURL)
+	u, err := neturl.Parse(expanded)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// parseCountries parses a comma-separated list of ISO country codes.
+func parseCountries(s string) map[string]bool {
+	if s == "" {
+		return nil
+	}
+	set := map[string]bool{}
+	for _, c := range strings.Split(s, ",") {
+		if c = strings.ToUpper(strings.TrimSpace(c)); c != "" {
+			set[c] = true
+		}
+	}
+	return set
+}
+
+// filterCountries keeps the endpoints located in include (when non-empty)
+// and not in exclude. Endpoints without a known country fail an include list
+// but pass an exclude list.
+func filterCountries(allResults map[uint64][]result, include, exclude map[string]bool) map[uint64][]result {
+	if len(include) == 0 && len(exclude) == 0 {
+		return allResults
+	}
+	out := make(map[uint64][]result, len(allResults))
+	for cid, results := range allResults {
+		kept := []result{}
+		for _, r := range results {
+			if (len(include) > 0 && !include[r.Country]) || exclude[r.Country] {
+				continue
+			}
+			kept = append(kept, r)
+		}
+		out[cid] = kept
+	}
+	return out
+}
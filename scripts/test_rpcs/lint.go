@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	neturl "net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// configLint validates config.toml beyond what decoding checks, printing
+// each violation as file:line: message and exiting 1 if there are any.
+func configLint(args []string) {
+	path := findConfig("config.toml")
+	switch len(args) {
+	case 0:
+	case 1:
+		path = args[0]
+	default:
+		fmt.Fprintln(os.Stderr, "usage: test_rpcs config-lint [config.toml]")
+		os.Exit(2)
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	problems := lintConfig(string(src))
+	for _, p := range problems {
+		fmt.Printf("%s:%d: %s\n", path, p.line, p.msg)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+	fmt.Printf("%s: ok\n", path)
+}
+
+type lintProblem struct {
+	line int // 1-based; 0 when unknown
+	msg  string
+}
+
+// lintConfig checks chain IDs, URL syntax and schemes, duplicate URLs, weights
+// matching rpcs, and that chains unknown to the binary have a [chain_meta.<id>] section.
+// toml.MetaData carries no positions, so lines are found by scanning src.
+func lintConfig(src string) []lintProblem {
+	var cfg config
+	md, err := toml.Decode(src, &cfg)
+	if err != nil {
+		line := 0
+		if pe, ok := err.(toml.ParseError); ok {
+			line = pe.Position.Line
+		}
+		return []lintProblem{{line, err.Error()}}
+	}
+	lines := strings.Split(src, "\n")
+	var problems []lintProblem
+	add := func(line int, format string, a ...any) {
+		problems = append(problems, lintProblem{line, fmt.Sprintf(format, a...)})
+	}
+
+	for _, k := range md.Undecoded() {
+		add(findLine(lines, 0, k[len(k)-1]), "unknown key %s", k)
+	}
+
+	seen := map[string]string{} // URL -> "chains.<id> line N"
+	lastLine := map[string]int{}
+	for _, id := range slices.Sorted(maps.Keys(cfg.Chains)) {
+		header := findLine(lines, 0, "[chains."+id+"]")
+		cid, err := strconv.ParseUint(id, 10, 64)
+		if err != nil || cid == 0 {
+			add(header, "chains.%s: chain ID must be a positive integer", id)
+		} else if _, ok := chains[cid]; !ok && cfg.ChainMeta[id].Name == "" && cfg.ChainMetaURL == "" {
+			// With chain_meta_url the chain may be defined remotely; lint
+			// stays offline and cannot tell.
+			add(header, "chains.%s: unknown chain needs a [chain_meta.%s] section with a name and deploy_block", id, id)
+		}
+		for _, u := range cfg.Chains[id].RPCs {
+			line := findLine(lines, max(header, lastLine[u]), strconv.Quote(u))
+			lastLine[u] = line
+			if msg := lintURL(u); msg != "" {
+				add(line, "chains.%s: %s: %s", id, u, msg)
+			}
+			where := fmt.Sprintf("chains.%s line %d", id, line)
+			if prev, dup := seen[u]; dup {
+				add(line, "chains.%s: %s duplicates %s", id, u, prev)
+				continue
+			}
+			seen[u] = where
+		}
+		if w := cfg.Chains[id].Weights; len(w) > 0 {
+			line := findLine(lines, header, "weights")
+			if len(w) != len(cfg.Chains[id].RPCs) {
+				add(line, "chains.%s: %d weights for %d rpcs", id, len(w), len(cfg.Chains[id].RPCs))
+			}
+			if slices.ContainsFunc(w, func(n int) bool { return n < 1 }) {
+				add(line, "chains.%s: weights must be positive", id)
+			}
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(cfg.ChainMeta)) {
+		m := cfg.ChainMeta[id]
+		header := findLine(lines, 0, "[chain_meta."+id+"]")
+		if cid, err := strconv.ParseUint(id, 10, 64); err != nil || cid == 0 {
+			add(header, "chain_meta.%s: chain ID must be a positive integer", id)
+		}
+		if m.Name == "" {
+			add(header, "chain_meta.%s: name is required", id)
+		}
+		// 0 is a valid deploy block (seed-from-chainlist writes it as a
+		// placeholder), so only a missing key is an error.
+		if !md.IsDefined("chain_meta", id, "deploy_block") {
+			add(header, "chain_meta.%s: deploy_block is required", id)
+		}
+	}
+	slices.SortStableFunc(problems, func(a, b lintProblem) int { return a.line - b.line })
+	return problems
+}
+
+// lintURL returns why u is not a usable endpoint URL, or "".
+func lintURL(u string) string {
+	p, err := neturl.Parse(envRef.ReplaceAllString(u, "x"))
+	if err != nil {
+		return "invalid URL"
+	}
+	switch p.Scheme {
+	case "http", "https", "ws", "wss":
+	default:
+		return fmt.Sprintf("scheme %q is not http, https, ws or wss", p.Scheme)
+	}
+	if p.Host == "" {
+		return "missing host"
+	}
+	return ""
+}
+
+// findLine returns the 1-based number of the first line after line `after`
+// that contains s, or `after` when there is none.
+func findLine(lines []string, after int, s string) int {
+	for i := after; i < len(lines); i++ {
+		if strings.Contains(lines[i], s) {
+			return i + 1
+		}
+	}
+	return after
+}
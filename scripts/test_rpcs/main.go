@@ -1,38 +1,359 @@
 package main
 
 import (
+	"cmp"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"maps"
+	"net"
+	"net/http"
 	"os"
+	"runtime/pprof"
 	"slices"
 	"strconv"
 	"strings"
 	"sync"
-
-	"github.com/BurntSushi/toml"
+	"time"
 )
 
+// opts holds command-line settings consulted outside main.
+var opts struct {
+	verbose            bool
+	quiet              bool
+	checkTrace         bool
+	checkSubscribe     bool
+	checkCall          bool
+	checkGasPrice      bool
+	checkUncle         bool
+	checkBlob          bool
+	checkTxpool        bool
+	checkAccounts      bool
+	checkConsistency   bool
+	checkReorg         bool
+	ipinfo             bool
+	sampleTxs          sampleTxFlag
+	maxBytes           int64
+	rpcCallCount       int
+	largeLogRange      bool
+	minReachable       int
+	noColor            bool
+	checkLogsByHash    bool
+	maxResponse        byteSize
+	weights            scoreWeights
+	reportUnstable     bool
+	checkPersonal      bool
+	checkGetProof      bool
+	excludeCDN         bool
+	checkListening     bool
+	checkPeerCount     bool
+	checkStorageAt     bool
+	checkSubscribeLogs bool
+	maxLatency         time.Duration
+	warmup             time.Duration
+	webhooks           stringsFlag
+	alertLatencyPct    float64
+	minArchiveDepth    uint64
+	checkTraceReplay   bool
+	gzip               bool
+	checkRawTx         bool
+	checkMaxLogs       bool
+	checkBlockReceipts bool
+	checkTxByHash      bool
+	checkTxReceipt     bool
+	checkStateAtDeploy bool
+	checkGetCode       bool
+	maxParallelProbes  int
+	checkJitter        bool
+	checkBlockByHash   bool
+	dnsTimeout         time.Duration
+	checkContentType   bool
+	checkEngine        bool
+	strictJSONRPC      bool
+}
+
+// byteSize is a flag value accepting sizes like 512KB, 10MB or 1048576.
+// Units are binary: KB = KiB = 1024 bytes.
+type byteSize int64
+
+func (b *byteSize) String() string { return fmtBytes(int64(*b)) }
+
+func (b *byteSize) Set(v string) error {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"GIB", 1 << 30}, {"GB", 1 << 30}, {"MIB", 1 << 20}, {"MB", 1 << 20}, {"KIB", 1 << 10}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid size %q", v)
+	}
+	*b = byteSize(n * mult)
+	return nil
+}
+
+// sampleTxFlag collects repeated -sample-tx <chain>=<txhash> values.
+type sampleTxFlag map[uint64]string
+
+func (f sampleTxFlag) String() string {
+	var parts []string
+	for _, cid := range slices.Sorted(maps.Keys(f)) {
+		parts = append(parts, fmt.Sprintf("%d=%s", cid, f[cid]))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f sampleTxFlag) Set(v string) error {
+	c, h, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("want <chain>=<txhash>, got %q", v)
+	}
+	cid, err := strconv.ParseUint(strings.TrimSpace(c), 10, 64)
+	if err != nil || cid == 0 {
+		return fmt.Errorf("invalid chain ID %q", c)
+	}
+	h = strings.TrimSpace(h)
+	if len(h) != 66 || !strings.HasPrefix(h, "0x") {
+		return fmt.Errorf("invalid transaction hash %q", h)
+	}
+	if _, err := hex.DecodeString(h[2:]); err != nil {
+		return fmt.Errorf("invalid transaction hash %q", h)
+	}
+	f[cid] = h
+	return nil
+}
+
+// subcommands run instead of the health check when named as the first
+// argument; each gets the remaining arguments.
+var subcommands = map[string]func(args []string){
+	"compare":             compareFiles,
+	"config-lint":         configLint,
+	"config-schema":       configSchema,
+	"seed-from-chainlist": seedFromChainlist,
+	"server":              serve,
+}
+
 func main() {
-	chainsFlag := flag.String("chains", "", "comma-separated chain IDs to test (default: all)")
-	writeFlag := flag.Bool("write", false, "overwrite config.toml with ranked results")
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
+	}
+	chainsFlag := flag.String("chains", "", "comma-separated chain IDs or names to test (default: all)")
+	flag.StringVar(chainsFlag, "include-chains", "", "alias for -chains")
+	configFlag := flag.String("config", "", "comma-separated config `files` to merge (default: config.toml in the working directory or above)")
+	mergeFlag := flag.String("config-merge-strategy", "append", "how later -config files combine a chain's rpcs with earlier ones: append or replace")
+	writeFlag := flag.Bool("write", false, "overwrite config.toml with ranked results (shorthand for -output-toml <config.toml>)")
+	backupCount := flag.Int("backup-count", 0, "with -write, keep only the `N` most recent config backups (0: all)")
+	outTOML := flag.String("output-toml", "", "write the ranked config to `file`")
+	outJSON := flag.String("output-json", "", "write all results as JSON to `file`")
+	outCSV := flag.String("output-csv", "", "write one CSV row per endpoint to `file`")
+	flag.BoolVar(&opts.noColor, "no-color", false, "disable the progress spinner (also via NO_COLOR)")
+	flag.BoolVar(&opts.verbose, "v", false, "verbose output: show per-endpoint error details")
+	flag.BoolVar(&opts.quiet, "quiet", false, "print only each chain's summary line, not its endpoint table")
+	compareFlag := flag.String("compare-chains", "", "print two tested chains' endpoints side by side, paired by hostname (`a,b`)")
+	diffFlag := flag.String("diff-against", "", "compare this run with a previous -output-json `file`")
+	flag.BoolVar(&opts.reportUnstable, "report-unstable", false, "with -diff-against, flag endpoints whose archive status flipped and comment them out of the TOML")
+	dbFlag := flag.String("db", "", "append each run's results to the SQLite database `file`")
+	dbQueryFlag := flag.String("db-query", "", "run an SQL `query` against -db's results table, print the rows, and exit")
+	concurrentChains := flag.Int("concurrent-chains", 0, "test at most `N` chains at once (0: all)")
+	failoverFlag := flag.Bool("simulate-failover", false, "walk each chain's RPCs in config order as the sync engine would, then exit")
+	formatFlag := flag.String("format", "text", "stdout format: text, mermaid or nginx")
+	flag.Var(&buckets, "latency-buckets", "`fast,slow` latency boundaries in ms for colouring endpoints by tier")
+	watchFlag := flag.Duration("watch", 0, "re-test every `interval` until interrupted (chains may override via check_interval_seconds)")
+	flag.BoolVar(&opts.excludeCDN, "exclude-cdn", false, "leave Cloudflare-proxied endpoints (Cf-Ray header) out of the recommended config")
+	flag.DurationVar(&opts.maxLatency, "max-latency", 0, "leave endpoints slower than this `duration` out of the recommended config")
+	flag.DurationVar(&opts.warmup, "warmup-duration", 0, "with -watch, run silent, discarded test cycles for this `duration` (at least one) before the first report")
+	flag.Var(&opts.webhooks, "alert-webhook", "with -watch, POST a JSON alert to `url` when an endpoint's status changes (repeatable)")
+	flag.Float64Var(&opts.alertLatencyPct, "alert-on-latency-pct", 0, "with -alert-webhook, alert when latency exceeds its 3-cycle average by more than `N` percent")
+	stripFlag := flag.Bool("strip-archive-only", false, "with -write, keep only archive-capable endpoints")
+	flag.BoolVar(&opts.checkTrace, "check-trace", false, "probe the Parity trace_ namespace via trace_block")
+	flag.BoolVar(&opts.checkTraceReplay, "check-trace-replay", false, "probe trace_replayBlockTransactions with vmTrace at the deploy block (60s timeout)")
+	flag.BoolVar(&opts.checkSubscribe, "check-subscribe", false, "probe eth_subscribe(newHeads) on ws:// and wss:// endpoints")
+	flag.BoolVar(&opts.checkSubscribeLogs, "check-subscribe-logs", false, "wait up to 30s for an identity contract log via eth_subscribe on ws:// and wss:// endpoints")
+	flag.BoolVar(&opts.checkCall, "eth-call-check", false, "verify eth_call of owner() on the identity contract")
+	flag.BoolVar(&opts.checkGasPrice, "gas-price-check", false, "record eth_gasPrice and flag nodes reporting zero")
+	flag.BoolVar(&opts.checkBlob, "check-blob", false, "verify eth_blobBaseFee on chains with EIP-4844 blobs")
+	flag.BoolVar(&opts.checkTxpool, "check-txpool", false, "record pending/queued counts from txpool_status")
+	flag.BoolVar(&opts.checkAccounts, "check-accounts", false, "flag nodes whose eth_accounts lists accounts (possible unlocked keys)")
+	flag.BoolVar(&opts.checkConsistency, "check-interval-consistency", false, "call eth_blockNumber 3 times back to back and flag heads that go backwards")
+	flag.BoolVar(&opts.checkLogsByHash, "check-logs-by-hash", false, "verify eth_getLogs by blockHash matches the range query at the deploy block")
+	flag.BoolVar(&opts.checkPersonal, "check-personal", false, "flag nodes that answer personal_listAccounts (exposed account management)")
+	flag.BoolVar(&opts.checkEngine, "check-engine-namespace", false, "raise a security warning if the engine_ (consensus-layer) namespace is exposed")
+	flag.BoolVar(&opts.checkGetProof, "check-get-proof", false, "verify eth_getProof for the identity contract at the deploy block")
+	flag.BoolVar(&opts.checkListening, "check-net-listening", false, "verify net_listening returns true")
+	flag.BoolVar(&opts.checkPeerCount, "check-peer-count", false, "record net_peerCount (shown with -v) and note nodes reporting 0 peers")
+	flag.BoolVar(&opts.checkStorageAt, "check-storage-at", false, "verify eth_getStorageAt returns non-zero state for the identity contract at the deploy block")
+	flag.BoolVar(&opts.checkStateAtDeploy, "check-state-at-deploy", false, "verify eth_getBalance of the identity contract succeeds at the deploy block")
+	flag.BoolVar(&opts.checkGetCode, "check-get-code", false, "verify eth_getCode returns the identity contract's bytecode at the deploy block")
+	flag.BoolVar(&opts.checkBlockByHash, "check-block-by-hash", false, "verify eth_getBlockByHash returns the deploy block found by number")
+	flag.BoolVar(&opts.checkBlockReceipts, "check-block-receipts", false, "verify eth_getBlockReceipts returns the deploy block's receipts")
+	flag.BoolVar(&opts.checkTxByHash, "check-tx-by-hash", false, "verify eth_getTransactionByHash finds a historical transaction (see -sample-tx)")
+	flag.BoolVar(&opts.checkTxReceipt, "check-tx-receipt", false, "verify eth_getTransactionReceipt returns the -check-tx-by-hash transaction's receipt with logs")
+	flag.BoolVar(&opts.checkRawTx, "check-raw-tx", false, "send an empty eth_sendRawTransaction to classify nodes as broadcast or read-only")
+	flag.BoolVar(&opts.checkUncle, "check-uncle", false, "verify eth_getUncleByBlockNumberAndIndex on chains with uncles")
+	flag.BoolVar(&opts.checkReorg, "check-reorg-depth", false, "sample the head twice, 2s apart, to detect reorgs")
+	flag.BoolVar(&opts.ipinfo, "ipinfo", false, "annotate endpoints with IP, country and ASN via ip-api.com")
+	filterCountry := flag.String("filter-country", "", "with -ipinfo, keep only endpoints in these comma-separated `countries` in the recommended config")
+	excludeCountry := flag.String("exclude-country", "", "with -ipinfo, leave endpoints in these comma-separated `countries` out of the recommended config")
+	reportByASN := flag.Bool("report-by-asn", false, "with -ipinfo, print archive and non-archive endpoint counts per ASN after the chain tables")
+	opts.sampleTxs = sampleTxFlag{}
+	flag.Var(opts.sampleTxs, "sample-tx", "known transaction `chain=txhash` for capability checks (repeatable)")
+	flag.BoolVar(&opts.checkJitter, "check-jitter", false, "measure latency jitter as the stddev of the -rpc-call-count samples (or 5 extra pings) and rank jittery endpoints lower")
+	flag.IntVar(&opts.rpcCallCount, "rpc-call-count", 0, "issue `N` sequential eth_blockNumber calls per endpoint and warn above 10% errors")
+	flag.IntVar(&opts.minReachable, "min-reachable", 0, "exit with status 2, before writing outputs, if any chain has fewer than `N` reachable endpoints")
+	flag.BoolVar(&opts.strictJSONRPC, "strict-json-rpc", false, "reject responses whose jsonrpc field is not \"2.0\"")
+	flag.BoolVar(&opts.checkContentType, "check-content-type", false, "fail HTTP responses whose Content-Type is not application/json")
+	flag.BoolVar(&opts.gzip, "gzip", false, "gzip request bodies and accept gzip responses")
+	flag.DurationVar(&opts.dnsTimeout, "dns-timeout", 0, "fail endpoints whose host does not resolve within `duration` (0: bounded only by the request timeout)")
+	flag.DurationVar(&transport.IdleConnTimeout, "idle-conn-timeout", transport.IdleConnTimeout, "close idle keep-alive connections after this `duration`")
+	flag.IntVar(&transport.MaxIdleConnsPerHost, "max-idle-conns-per-host", transport.MaxIdleConnsPerHost, "keep at most `N` idle connections per host")
+	traceHTTP := flag.Bool("trace-http", false, "log HTTP request and response headers to stderr (Authorization redacted)")
+	flag.IntVar(&retryPolicy.MaxRetries, "retries", retryPolicy.MaxRetries, "retry an HTTP 429 up to `N` times, honoring Retry-After (max 30s)")
+	flag.Int64Var(&opts.maxBytes, "max-bytes-per-endpoint", 0, "stop testing an endpoint after this many bytes (0: unlimited)")
+	perChainFlag := flag.String("per-chain-output", "", "write each chain's results as JSON to `dir`/<chain_id>.json")
+	opts.maxResponse = defaultMaxResponse
+	opts.weights = scoreWeights{Archive: 10, Range: 5, Latency: 1}
+	flag.Var(&opts.weights, "score-weights", "rank by a composite score with these `archive=N,range=N,latency=N` multipliers (default 10,5,1); without this flag the score is reported but ranking stays archive, then range, then latency")
+	flag.Var(&opts.maxResponse, "max-response-size", "cap on bytes read from a single RPC response")
+	metaCacheFlag := flag.String("cache-metadata", "", "cache chain ID and client version per endpoint in `file` for 24h")
+	cpuProfileFlag := flag.String("profile-cpu", "", "write a CPU profile to `file` for go tool pprof")
+	logCallsFlag := flag.String("log-rpc-calls", "", "append every JSON-RPC exchange as a JSON line to `file`")
+	flag.BoolVar(&opts.largeLogRange, "check-large-log-range", false, "after the top range step succeeds, also probe 100k, 200k, 500k and 1M blocks")
+	flag.Uint64Var(&opts.minArchiveDepth, "check-min-archive-block", 0, "treat nodes without the block `N` below head as non-archive")
+	flag.BoolVar(&opts.checkMaxLogs, "check-max-logs-per-response", false, "estimate the node's cap on logs per eth_getLogs response")
+	flag.IntVar(&opts.maxParallelProbes, "max-parallel-probes", 1, "run up to `N` (at most 4) eth_getLogs range probes of an endpoint at once")
+	stepsFlag := flag.String("range-steps", "", "comma-separated eth_getLogs block spans to probe (default 500,2000,5000,10000,50000)")
+	mockFlag := flag.String("mock-server", "", "serve a local JSON-RPC stub on `addr` and test against it instead of config URLs")
+	idFlag := flag.String("rpc-id-strategy", "fixed", "JSON-RPC request IDs: sequential, random or fixed[=N]")
 	flag.Parse()
+	start := time.Now()
+
+	if *cpuProfileFlag != "" {
+		f, err := os.Create(*cpuProfileFlag)
+		if err != nil {
+			log.Fatalf("-profile-cpu: %v", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			log.Fatalf("-profile-cpu: %v", err)
+		}
+		defer f.Close()
+		defer pprof.StopCPUProfile()
+	}
+
+	if *dbQueryFlag != "" {
+		if *dbFlag == "" {
+			log.Fatal("-db-query requires -db")
+		}
+		if err := queryResultsDB(os.Stdout, *dbFlag, *dbQueryFlag); err != nil {
+			log.Fatalf("-db-query: %v", err)
+		}
+		return
+	}
+
+	if len(opts.webhooks) > 0 && *watchFlag == 0 {
+		log.Fatal("-alert-webhook requires -watch")
+	}
+	if (*filterCountry != "" || *excludeCountry != "") && !opts.ipinfo {
+		log.Fatal("-filter-country and -exclude-country require -ipinfo")
+	}
+	if *reportByASN && !opts.ipinfo {
+		log.Fatal("-report-by-asn requires -ipinfo")
+	}
 
-	cfgPath := findConfig("config.toml")
+	if *mergeFlag != "append" && *mergeFlag != "replace" {
+		log.Fatalf("-config-merge-strategy: unknown strategy %q (want append or replace)", *mergeFlag)
+	}
 
-	var cfg config
-	if _, err := toml.DecodeFile(cfgPath, &cfg); err != nil {
-		log.Fatalf("reading %s: %v", cfgPath, err)
+	switch *formatFlag {
+	case "text":
+	case "mermaid", "nginx":
+		progress = os.Stderr
+	default:
+		log.Fatalf("-format: unknown format %q (want text, mermaid or nginx)", *formatFlag)
+	}
+
+	if err := setIDStrategy(*idFlag); err != nil {
+		log.Fatalf("-rpc-id-strategy: %v", err)
+	}
+	if opts.maxParallelProbes < 1 || opts.maxParallelProbes > 4 {
+		log.Fatalf("-max-parallel-probes: want 1 to 4, got %d", opts.maxParallelProbes)
+	}
+	if *stepsFlag != "" {
+		steps, err := parseRangeSteps(*stepsFlag)
+		if err != nil {
+			log.Fatalf("-range-steps: %v", err)
+		}
+		rangeSteps = steps
+	}
+
+	if *traceHTTP {
+		client.Transport = traceTransport{transport, os.Stderr}
+		slowClient.Transport = client.Transport
+	}
+
+	if *logCallsFlag != "" {
+		f, err := os.OpenFile(*logCallsFlag, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("-log-rpc-calls: %v", err)
+		}
+		defer f.Close()
+		rpcLog = f
+	}
+
+	if *metaCacheFlag != "" {
+		c, err := loadMetaCache(*metaCacheFlag)
+		if err != nil {
+			log.Fatalf("-cache-metadata: %v", err)
+		}
+		endpointMetaCache = c
+	}
+
+	cfgPaths := configPaths(*configFlag)
+	cfgPath := cfgPaths[0]
+	if len(cfgPaths) > 1 && *writeFlag {
+		log.Fatal("-write needs a single -config file; use -output-toml for merged configs")
+	}
+
+	cfg, err := loadConfigs(cfgPaths, *mergeFlag)
+	if err != nil {
+		log.Fatalf("reading config: %v", err)
+	}
+	warnUnsetEnv(cfg)
+
+	if *mockFlag != "" {
+		if *writeFlag {
+			log.Fatal("-mock-server cannot be combined with -write")
+		}
+		ln, err := net.Listen("tcp", *mockFlag)
+		if err != nil {
+			log.Fatalf("mock server: %v", err)
+		}
+		go http.Serve(ln, mockHandler())
+		port := ln.Addr().(*net.TCPAddr).Port
+		for cid, cc := range cfg.Chains {
+			cc.RPCs = []string{fmt.Sprintf("http://127.0.0.1:%d/%s", port, cid)}
+			cfg.Chains[cid] = cc
+		}
 	}
 
 	filter := map[uint64]bool{}
 	if *chainsFlag != "" {
 		for _, s := range strings.Split(*chainsFlag, ",") {
-			id, _ := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
-			if id > 0 {
-				filter[id] = true
+			id, ok := chainID(s)
+			if !ok {
+				log.Fatalf("-chains: unknown chain %q", s)
 			}
+			filter[id] = true
 		}
 	}
 
@@ -40,12 +361,195 @@ func main() {
 	for _, c := range cfg.Chains {
 		total += len(c.RPCs)
 	}
-	fmt.Printf("ERC-8004 RPC Health Check — %d endpoints across %d chains\n", total, len(cfg.Chains))
+	fmt.Fprintf(progress, "ERC-8004 RPC Health Check — %d endpoints across %d chains\n", total, len(cfg.Chains))
 
-	allResults := make(map[uint64][]result)
-	var mu sync.Mutex
-	var wg sync.WaitGroup
+	targets := buildTargets(cfg, filter)
+
+	if *failoverFlag {
+		slices.SortFunc(targets, func(a, b target) int { return cmp.Compare(a.cid, b.cid) })
+		for _, t := range targets {
+			simulateFailover(t)
+		}
+		return
+	}
+
+	if *watchFlag > 0 {
+		if *writeFlag {
+			log.Fatal("-watch cannot be combined with -write")
+		}
+		watch(targets, *watchFlag)
+		return
+	}
+
+	if spinnerEnabled() {
+		spin = newSpinner(os.Stderr)
+	}
+	allResults := testTargets(targets, *concurrentChains)
+	if spin != nil {
+		spin.close()
+	}
+
+	if opts.ipinfo {
+		if err := annotateIPs(allResults); err != nil {
+			log.Printf("ipinfo: %v", err)
+		}
+	}
+
+	switch *formatFlag {
+	case "mermaid":
+		fmt.Print(generateMermaid(allResults))
+	case "nginx":
+		fmt.Print(generateNginx(allResults, cfg.Chains))
+	default:
+		for _, cid := range slices.Sorted(maps.Keys(allResults)) {
+			printChain(cid, chains[cid], allResults[cid])
+		}
+		if *reportByASN {
+			printASNReport(os.Stdout, allResults)
+		}
+	}
+
+	if *dbFlag != "" {
+		if err := saveResults(*dbFlag, start, allResults); err != nil {
+			log.Printf("saving results to %s: %v", *dbFlag, err)
+		}
+	}
 
+	if *compareFlag != "" {
+		ids := strings.Split(*compareFlag, ",")
+		a, okA := chainID(ids[0])
+		var b uint64
+		okB := len(ids) == 2
+		if okB {
+			b, okB = chainID(ids[1])
+		}
+		switch {
+		case !okA || !okB:
+			log.Printf("-compare-chains: want two chains, got %q", *compareFlag)
+		case allResults[a] == nil || allResults[b] == nil:
+			log.Printf("-compare-chains: chains %d and %d were not both tested", a, b)
+		default:
+			printChainComparison(progress, a, b, allResults)
+		}
+	}
+
+	if *diffFlag != "" {
+		prev, err := loadResults(*diffFlag)
+		if err != nil {
+			log.Fatalf("-diff-against: %v", err)
+		}
+		diffResults(progress, prev, allResults)
+	}
+
+	if endpointMetaCache != nil {
+		if err := endpointMetaCache.save(*metaCacheFlag); err != nil {
+			log.Printf("saving metadata cache: %v", err)
+		}
+	}
+
+	if opts.minReachable > 0 {
+		var short []string
+		for _, cid := range slices.Sorted(maps.Keys(allResults)) {
+			n := 0
+			for _, r := range allResults[cid] {
+				if r.Reachable {
+					n++
+				}
+			}
+			if n < opts.minReachable {
+				short = append(short, fmt.Sprintf("%s (%d)", chains[cid].Name, n))
+			}
+		}
+		if len(short) > 0 {
+			log.Printf("fewer than %d reachable endpoints: %s", opts.minReachable, strings.Join(short, ", "))
+			// CI reads the summary precisely when the run fails.
+			if err := writeSummary(os.Stderr, allResults, time.Since(start)); err != nil {
+				log.Printf("writing summary: %v", err)
+			}
+			pprof.StopCPUProfile() // os.Exit skips the deferred stop
+			os.Exit(2)
+		}
+	}
+
+	if *perChainFlag != "" {
+		if err := writeChainFiles(*perChainFlag, allResults); err != nil {
+			log.Fatalf("writing per-chain output: %v", err)
+		}
+	}
+
+	if *writeFlag && *outTOML == "" {
+		*outTOML = cfgPath
+	}
+	tomlResults := filterCountries(allResults, parseCountries(*filterCountry), parseCountries(*excludeCountry))
+	tomlOut := cfg.headerTOML() + generateTOML(tomlResults, cfg.Chains, *outTOML != "" && *stripFlag) + cfg.chainMetaTOML()
+	if *formatFlag == "text" {
+		fmt.Printf("\n%s\n  RECOMMENDED config.toml\n%s\n\n%s",
+			strings.Repeat("─", 90), strings.Repeat("─", 90), tomlOut)
+	}
+
+	outputs := map[string]func() ([]byte, error){}
+	if *outTOML != "" {
+		outputs[*outTOML] = func() ([]byte, error) { return []byte(tomlOut), nil }
+	}
+	if *outJSON != "" {
+		outputs[*outJSON] = func() ([]byte, error) { return generateJSON(allResults) }
+	}
+	if *outCSV != "" {
+		outputs[*outCSV] = func() ([]byte, error) { return generateCSV(allResults) }
+	}
+	if *writeFlag {
+		// -output-toml may redirect the write; back up whatever it replaces.
+		if _, err := os.Stat(*outTOML); err == nil {
+			if err := backupFile(*outTOML, *backupCount); err != nil {
+				log.Fatalf("backing up %s: %v", *outTOML, err)
+			}
+		}
+	}
+	if err := writeOutputs(outputs); err != nil {
+		log.Fatal(err)
+	}
+	for _, path := range slices.Sorted(maps.Keys(outputs)) {
+		fmt.Fprintf(progress, "  ✅ Written to %s\n", path)
+	}
+	if *outTOML == "" && *formatFlag == "text" && len(cfgPaths) == 1 {
+		fmt.Printf("  💡 Pass -write to overwrite %s automatically.\n", cfgPath)
+	}
+
+	if err := writeSummary(os.Stderr, allResults, time.Since(start)); err != nil {
+		log.Printf("writing summary: %v", err)
+	}
+}
+
+// configPaths splits a -config value into its files, defaulting to the
+// config.toml found by findConfig.
+func configPaths(flagValue string) []string {
+	var paths []string
+	for _, p := range strings.Split(flagValue, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		paths = []string{findConfig("config.toml")}
+	}
+	return paths
+}
+
+// progress receives status lines; it moves to stderr when stdout carries a
+// machine-readable format.
+var progress io.Writer = os.Stdout
+
+// target is a chain selected for testing.
+type target struct {
+	cid  uint64
+	meta chainMeta
+	cfg  chainCfg
+}
+
+// buildTargets selects the configured chains to test, applying the filter
+// (empty: all) and any deploy_block overrides.
+func buildTargets(cfg config, filter map[uint64]bool) []target {
+	var targets []target
 	for cidStr, cc := range cfg.Chains {
 		cid, _ := strconv.ParseUint(cidStr, 10, 64)
 		if len(filter) > 0 && !filter[cid] {
@@ -53,54 +557,102 @@ func main() {
 		}
 		meta, ok := chains[cid]
 		if !ok {
-			fmt.Printf("  [%6d] unknown chain, skipping\n", cid)
+			fmt.Fprintf(progress, "  [%6d] unknown chain, skipping\n", cid)
 			continue
 		}
+		if cc.DeployBlock > 0 {
+			log.Printf("warning: chain %d: deploy_block = %d overrides compiled-in %d", cid, cc.DeployBlock, meta.DeployBlock)
+			meta.DeployBlock = cc.DeployBlock
+		}
+		targets = append(targets, target{cid, meta, cc})
+	}
+	return targets
+}
 
+// testTargets tests every target's chain concurrently, at most maxChains at
+// once when maxChains > 0.
+func testTargets(targets []target, maxChains int) map[uint64][]result {
+	allResults := make(map[uint64][]result)
+	var mu sync.Mutex
+	var sem chan struct{}
+	if maxChains > 0 {
+		sem = make(chan struct{}, maxChains)
+	}
+	var wg sync.WaitGroup
+	for _, t := range targets {
 		wg.Go(func() {
-			rpcs := cc.RPCs
-			fmt.Printf("  [%6d] %s (%d RPCs) ...\n", cid, meta.Name, len(rpcs))
-
-			results := make([]result, len(rpcs))
-			var inner sync.WaitGroup
-			for i, u := range rpcs {
-				inner.Add(1)
-				go func() {
-					defer inner.Done()
-					results[i] = testEndpoint(u, meta.DeployBlock)
-				}()
-			}
-			inner.Wait()
-
-			n := 0
-			for _, r := range results {
-				if r.Archive {
-					n++
-				}
+			if sem != nil {
+				sem <- struct{}{}
+				defer func() { <-sem }()
 			}
-			fmt.Printf("  [%6d] %s done: %d/%d archive-capable\n", cid, meta.Name, n, len(rpcs))
-
+			results := testChain(t)
 			mu.Lock()
-			allResults[cid] = results
+			allResults[t.cid] = results
 			mu.Unlock()
 		})
 	}
 	wg.Wait()
+	return allResults
+}
+
+// testChain tests all of a chain's endpoints concurrently, reporting progress.
+func testChain(t target) []result {
+	rpcs := t.cfg.RPCs
+	spin.start(t.meta.Name, fmt.Sprintf("  [%6d] %s (%d RPCs) ...\n", t.cid, t.meta.Name, len(rpcs)))
 
-	for _, cid := range slices.Sorted(maps.Keys(allResults)) {
-		printChain(cid, chains[cid], allResults[cid])
+	// The preflight only pays for itself when -min-reachable may fail fast;
+	// otherwise each endpoint's own ping finds the dead ones.
+	if opts.minReachable > 0 {
+		if results, ok := preflight(rpcs); !ok {
+			spin.finish(t.meta.Name, fmt.Sprintf("  [%6d] ✗ %s ALL ENDPOINTS UNREACHABLE\n", t.cid, t.meta.Name))
+			return results
+		}
 	}
 
-	tomlOut := generateTOML(allResults)
-	fmt.Printf("\n%s\n  RECOMMENDED config.toml\n%s\n\n%s",
-		strings.Repeat("─", 90), strings.Repeat("─", 90), tomlOut)
+	results := make([]result, len(rpcs))
+	var wg sync.WaitGroup
+	for i, u := range rpcs {
+		wg.Go(func() {
+			expanded := expandURL(u)
+			results[i] = testEndpoint(expanded, t.cid, t.meta)
+			results[i].setURL(u)
+			results[i].Score = results[i].score(opts.weights)
+		})
+	}
+	wg.Wait()
 
-	if *writeFlag {
-		if err := os.WriteFile(cfgPath, []byte(tomlOut), 0644); err != nil {
-			log.Fatalf("writing %s: %v", cfgPath, err)
+	n := 0
+	for _, r := range results {
+		if r.Archive {
+			n++
 		}
-		fmt.Printf("  ✅ Written to %s\n", cfgPath)
-	} else {
-		fmt.Printf("  💡 Pass -write to overwrite %s automatically.\n", cfgPath)
 	}
+	mark := "✓"
+	if n == 0 {
+		mark = "✗"
+	}
+	spin.finish(t.meta.Name, fmt.Sprintf("  [%6d] %s %s done: %d/%d archive-capable\n", t.cid, mark, t.meta.Name, n, len(rpcs)))
+	return results
+}
+
+// preflight pings every endpoint and reports whether any answered, so chains
+// that are entirely down skip the archive and range probes. The returned
+// results carry the ping outcome only.
+func preflight(rpcs []string) ([]result, bool) {
+	results := make([]result, len(rpcs))
+	var wg sync.WaitGroup
+	for i, u := range rpcs {
+		wg.Go(func() {
+			expanded := expandURL(u)
+			results[i].setURL(u)
+			ok, ms, head, fail := checkPing(expanded)
+			if !ok {
+				results[i].fail(fail)
+				return
+			}
+			results[i].Reachable, results[i].LatencyMs, results[i].LatestBlock = true, ms, head
+		})
+	}
+	wg.Wait()
+	return results, slices.ContainsFunc(results, func(r result) bool { return r.Reachable })
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	neturl "net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// latencyBuckets are the boundaries, in milliseconds, of the latency tiers
+// used to colour endpoints: fast below Fast, slow from Slow up.
+type latencyBuckets struct{ Fast, Slow float64 }
+
+var buckets = latencyBuckets{Fast: 100, Slow: 500}
+
+func (b *latencyBuckets) String() string { return fmt.Sprintf("%g,%g", b.Fast, b.Slow) }
+
+// Set parses -latency-buckets as "fast,slow".
+func (b *latencyBuckets) Set(v string) error {
+	fast, slow, ok := strings.Cut(v, ",")
+	if !ok {
+		return fmt.Errorf("want fast,slow in ms, got %q", v)
+	}
+	f, err1 := strconv.ParseFloat(strings.TrimSpace(fast), 64)
+	s, err2 := strconv.ParseFloat(strings.TrimSpace(slow), 64)
+	switch {
+	case err1 != nil || f <= 0:
+		return fmt.Errorf("invalid threshold %q", fast)
+	case err2 != nil || s <= 0:
+		return fmt.Errorf("invalid threshold %q", slow)
+	case f >= s:
+		return fmt.Errorf("fast threshold %g must be below slow threshold %g", f, s)
+	}
+	b.Fast, b.Slow = f, s
+	return nil
+}
+
+func latencyTier(ms float64) string {
+	switch {
+	case ms < buckets.Fast:
+		return "fast"
+	case ms < buckets.Slow:
+		return "medium"
+	default:
+		return "slow"
+	}
+}
+
+// generateMermaid renders a left-to-right graph with one node per chain and
+// one leaf per archive-capable endpoint, coloured by latency tier.
+func generateMermaid(allResults map[uint64][]result) string {
+	var b strings.Builder
+	b.WriteString("graph LR\n")
+	for _, cid := range slices.Sorted(maps.Keys(allResults)) {
+		results := allResults[cid]
+		sortResults(results)
+		fmt.Fprintf(&b, "  c%d[\"%s (%d)\"]\n", cid, mermaidLabel(chains[cid].Name), cid)
+		for i, r := range results {
+			if !r.Archive {
+				continue
+			}
+			id := fmt.Sprintf("c%de%d", cid, i)
+			fmt.Fprintf(&b, "  c%d --> %s[\"%s<br/>%.0fms\"]:::%s\n",
+				cid, id, mermaidLabel(shortURL(r.URL)), r.LatencyMs, latencyTier(r.LatencyMs))
+		}
+	}
+	b.WriteString("  classDef fast fill:#d4edda,stroke:#28a745\n")
+	b.WriteString("  classDef medium fill:#fff3cd,stroke:#ffc107\n")
+	b.WriteString("  classDef slow fill:#f8d7da,stroke:#dc3545\n")
+	return b.String()
+}
+
+// shortURL drops the scheme and any userinfo, query or fragment.
+func shortURL(raw string) string {
+	u, err := neturl.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	return strings.TrimSuffix(u.Host+u.Path, "/")
+}
+
+// mermaidLabel escapes characters that terminate a quoted Mermaid label.
+func mermaidLabel(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;").Replace(s)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// metaTTL is how long cached endpoint metadata is trusted.
+const metaTTL = 24 * time.Hour
+
+type endpointMeta struct {
+	ChainID       uint64    `json:"chain_id"`
+	ClientVersion string    `json:"client_version"`
+	CachedAt      time.Time `json:"cached_at"`
+}
+
+// metaCache is the -cache-metadata store, keyed by the endpoint's displayURL
+// so no credential or expanded ${VAR} reaches the file. A nil *metaCache
+// disables caching.
+type metaCache struct {
+	mu      sync.Mutex
+	entries map[string]endpointMeta
+}
+
+var endpointMetaCache *metaCache
+
+func loadMetaCache(path string) (*metaCache, error) {
+	c := &metaCache{entries: map[string]endpointMeta{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// get returns the cached metadata for url if it is younger than metaTTL.
+func (c *metaCache) get(url string) (endpointMeta, bool) {
+	if c == nil {
+		return endpointMeta{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, ok := c.entries[displayURL(url)]
+	return m, ok && time.Since(m.CachedAt) < metaTTL
+}
+
+func (c *metaCache) put(url string, m endpointMeta) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m.CachedAt = time.Now().UTC()
+	c.entries[displayURL(url)] = m
+}
+
+func (c *metaCache) save(path string) error {
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
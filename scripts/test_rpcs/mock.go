@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// mockNode is a canned JSON-RPC node for offline runs (-mock-server) and
+// tests. It answers eth_blockNumber, eth_chainId, web3_clientVersion and
+// eth_getLogs; everything else is "method not found".
+type mockNode struct {
+	ChainID  uint64
+	Head     uint64
+	Logs     int    // logs returned by every accepted eth_getLogs
+	MaxRange uint64 // eth_getLogs spans above this are rejected; 0 is unlimited
+}
+
+func (m mockNode) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var in rpcReq
+	if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		JSONRPC string `json:"jsonrpc"`
+		ID      int    `json:"id"`
+		rpcResp
+	}{"2.0", in.ID, m.handle(in)})
+}
+
+func (m mockNode) handle(in rpcReq) rpcResp {
+	switch in.Method {
+	case "eth_blockNumber":
+		return mockResult(toHex(m.Head))
+	case "eth_chainId":
+		return mockResult(toHex(m.ChainID))
+	case "web3_clientVersion":
+		return mockResult("test_rpcs/mock")
+	case "eth_getLogs":
+		from, to, ok := filterSpan(in.Params)
+		if !ok {
+			return rpcResp{Error: &rpcError{-32602, "invalid params"}}
+		}
+		if m.MaxRange > 0 && to-from > m.MaxRange {
+			return rpcResp{Error: &rpcError{-32005, "block range too large"}}
+		}
+		logs := make([]map[string]string, m.Logs)
+		for i := range logs {
+			logs[i] = map[string]string{"address": identityAddr, "blockNumber": toHex(from)}
+		}
+		return mockResult(logs)
+	default:
+		return rpcResp{Error: &rpcError{-32601, "method not found"}}
+	}
+}
+
+func mockResult(v any) rpcResp {
+	b, _ := json.Marshal(v)
+	return rpcResp{Result: b}
+}
+
+// filterSpan extracts fromBlock/toBlock from eth_getLogs params.
+func filterSpan(params []any) (from, to uint64, ok bool) {
+	if len(params) != 1 {
+		return 0, 0, false
+	}
+	f, _ := params[0].(map[string]any)
+	fs, _ := f["fromBlock"].(string)
+	ts, _ := f["toBlock"].(string)
+	from, err1 := strconv.ParseUint(trimHex(fs), 16, 64)
+	to, err2 := strconv.ParseUint(trimHex(ts), 16, 64)
+	return from, to, err1 == nil && err2 == nil && from <= to
+}
+
+func trimHex(s string) string {
+	if len(s) > 2 && s[:2] == "0x" {
+		return s[2:]
+	}
+	return s
+}
+
+// mockHandler serves one mockNode per chain under /<chain-id>, reporting a
+// head one million blocks past the chain's deploy block.
+func mockHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /{chain}", func(w http.ResponseWriter, req *http.Request) {
+		cid, err := strconv.ParseUint(req.PathValue("chain"), 10, 64)
+		if err != nil {
+			http.NotFound(w, req)
+			return
+		}
+		mockNode{ChainID: cid, Head: chains[cid].DeployBlock + 1_000_000, Logs: 3}.ServeHTTP(w, req)
+	})
+	return mux
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	neturl "net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// generateNginx renders one weighted nginx upstream block per chain from the
+// endpoints generateTOML would recommend. Upstream servers are host:port
+// only, so WebSocket endpoints and URLs with a path or query (often an API
+// key) are listed as comments instead.
+func generateNginx(allResults map[uint64][]result, cfgs map[string]chainCfg) string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "# Generated by test_rpcs; weights from config.toml or test scores.")
+	for _, cid := range slices.Sorted(maps.Keys(allResults)) {
+		results := allResults[cid]
+		sortResults(results)
+		assignWeights(results, cfgs[strconv.FormatUint(cid, 10)])
+		drop := redundantTransports(results)
+		fmt.Fprintf(&b, "\n# %s (chain %d)\n", chains[cid].Name, cid)
+		var servers []string
+		for _, r := range results {
+			if !recommended(r, false, drop) {
+				continue
+			}
+			addr, ok := upstreamAddr(r.URL)
+			if !ok {
+				fmt.Fprintf(&b, "# skipped %s: not expressible as an upstream server\n", r.URL)
+				continue
+			}
+			servers = append(servers, fmt.Sprintf("    server %s weight=%d;\n", addr, r.Weight))
+		}
+		// nginx rejects an upstream block without servers.
+		if len(servers) == 0 {
+			b.WriteString("# no usable endpoints\n")
+			continue
+		}
+		fmt.Fprintf(&b, "upstream rpc_%d {\n%s}\n", cid, strings.Join(servers, ""))
+	}
+	return b.String()
+}
+
+// upstreamAddr returns host:port for a plain http(s) URL with no path
+// beyond "/" and no query.
+func upstreamAddr(raw string) (string, bool) {
+	u, err := neturl.Parse(raw)
+	if err != nil || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return "", false
+	}
+	port := u.Port()
+	switch {
+	case port != "":
+	case u.Scheme == "https":
+		port = "443"
+	case u.Scheme == "http":
+		port = "80"
+	default:
+		return "", false
+	}
+	return fmt.Sprintf("%s:%s", u.Hostname(), port), true
+}
@@ -1,12 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"cmp"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
 	"maps"
+	"math"
+	"math/big"
+	neturl "net/url"
+	"os"
+	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
 )
 
@@ -16,36 +27,333 @@ func (r result) icon() string {
 		return "✗"
 	case !r.Archive:
 		return "△"
+	case len(r.Warnings) > 0:
+		return "⚠"
 	default:
 		return "✓"
 	}
 }
 
+// jitterPenaltyMs is the latency jitter above which an endpoint ranks as if
+// its latency were mean plus jitter.
+const jitterPenaltyMs = 100
+
+// rankLatency is the latency used for ranking: LatencyMs, penalized by the
+// jitter when it exceeds jitterPenaltyMs.
+func (r result) rankLatency() float64 {
+	if r.LatencyJitterMs > jitterPenaltyMs {
+		return r.LatencyMs + r.LatencyJitterMs
+	}
+	return r.LatencyMs
+}
+
+// slow reports whether r exceeds -max-latency.
+func (r result) slow() bool {
+	return opts.maxLatency > 0 && r.LatencyMs > float64(opts.maxLatency.Milliseconds())
+}
+
+// optionalCol is a table column shown only when its check is enabled.
+type optionalCol struct {
+	name string
+	on   *bool
+	cell func(result) string
+}
+
+var optionalCols = []optionalCol{
+	{"Trace", &opts.checkTrace, func(r result) string { return yesNo(r.TraceOK) }},
+	{"Replay", &opts.checkTraceReplay, func(r result) string { return yesNo(r.TraceReplayOK) }},
+	{"Subs", &opts.checkSubscribe, func(r result) string { return yesNo(r.SubscribeOK) }},
+	{"SubLog", &opts.checkSubscribeLogs, func(r result) string { return yesNo(r.LogSubscribeOK) }},
+	{"Call", &opts.checkCall, func(r result) string { return yesNo(r.CallOK) }},
+	{"Gwei", &opts.checkGasPrice, func(r result) string { return fmtGwei(r.GasPrice) }},
+	{"Uncle", &opts.checkUncle, func(r result) string { return yesNoSkip(r.UncleOK) }},
+	{"Blob", &opts.checkBlob, func(r result) string { return yesNoSkip(r.BlobBaseFeeOK) }},
+	{"Pool", &opts.checkTxpool, fmtTxpool},
+	{"Accts", &opts.checkAccounts, func(r result) string { return yesNo(r.AccountsExposed) }},
+	{"Mono", &opts.checkConsistency, func(r result) string { return yesNo(r.BlockNumberConsistent) }},
+	{"ByHash", &opts.checkLogsByHash, func(r result) string { return yesNo(r.LogsByHashOK) }},
+	{"Pers", &opts.checkPersonal, func(r result) string { return yesNo(r.PersonalExposed) }},
+	{"Engine", &opts.checkEngine, func(r result) string { return yesNo(r.EngineExposed) }},
+	{"Proof", &opts.checkGetProof, func(r result) string { return yesNo(r.GetProofOK) }},
+	{"Listen", &opts.checkListening, func(r result) string { return yesNo(r.Listening) }},
+	{"Store", &opts.checkStorageAt, func(r result) string { return yesNo(r.StorageAtOK) }},
+	{"State", &opts.checkStateAtDeploy, func(r result) string { return yesNo(r.StateAtDeployOK) }},
+	{"Code", &opts.checkGetCode, func(r result) string { return yesNo(r.GetCodeOK) }},
+	{"BlkHash", &opts.checkBlockByHash, func(r result) string { return yesNo(r.BlockByHashOK) }},
+	{"Rcpts", &opts.checkBlockReceipts, func(r result) string { return yesNo(r.BlockReceiptsOK) }},
+	{"TxHash", &opts.checkTxByHash, func(r result) string { return yesNo(r.TxByHashOK) }},
+	{"TxRcpt", &opts.checkTxReceipt, func(r result) string { return yesNo(r.TxReceiptOK) }},
+	{"RawTx", &opts.checkRawTx, func(r result) string { return r.RawTx }},
+	{"LogCap", &opts.checkMaxLogs, func(r result) string {
+		if r.MaxLogsPerResponse == 0 {
+			return "—"
+		}
+		return fmtInt(r.MaxLogsPerResponse)
+	}},
+	{"Reorg", &opts.checkReorg, func(r result) string { return yesNo(r.ReorgDetected) }},
+	{"CC", &opts.ipinfo, func(r result) string { return fmtCountry(r) }},
+}
+
+// fmtGwei renders a wei amount in gwei.
+func fmtGwei(wei *big.Int) string {
+	if wei == nil {
+		return "—"
+	}
+	g, _ := new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(1e9)).Float64()
+	return strconv.FormatFloat(g, 'g', 4, 64)
+}
+
+// fmtTxpool renders the txpool as pending/queued.
+func fmtTxpool(r result) string {
+	if r.TxpoolPending < 0 {
+		return "no"
+	}
+	return fmt.Sprintf("%d/%d", r.TxpoolPending, r.TxpoolQueued)
+}
+
+// fmtCountry renders the endpoint's country code, starred when its ASN is
+// shared with another endpoint of the chain.
+func fmtCountry(r result) string {
+	cc := r.Country
+	if cc == "" {
+		cc = "??"
+	}
+	if r.SharedASN {
+		cc += "*"
+	}
+	return cc
+}
+
+// yesNoSkip renders an optional outcome, with nil meaning "not applicable".
+func yesNoSkip(b *bool) string {
+	if b == nil {
+		return "—"
+	}
+	return yesNo(*b)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
+
+// extraCells renders the enabled optional columns for r, or their headers
+// when r is nil, each followed by a tab.
+func extraCells(r *result) string {
+	var b strings.Builder
+	for _, c := range optionalCols {
+		if !*c.on {
+			continue
+		}
+		v := c.name
+		if r != nil {
+			v = "—"
+			if r.Reachable {
+				v = c.cell(*r)
+			}
+		}
+		b.WriteString(v + "\t")
+	}
+	return b.String()
+}
+
 func printChain(cid uint64, meta chainMeta, results []result) {
 	sortResults(results)
+	stats := chainStats(results)
+	if opts.checkPersonal || opts.checkEngine {
+		// Surface exposed nodes first in the table only; the TOML keeps the
+		// ranked order.
+		results = slices.Clone(results)
+		slices.SortStableFunc(results, func(a, b result) int {
+			return cmp.Compare(btoi(a.PersonalExposed || a.EngineExposed), btoi(b.PersonalExposed || b.EngineExposed))
+		})
+	}
 	fmt.Printf("\n%s\n  %s (chain %d) — %d endpoints\n%s\n",
 		strings.Repeat("─", 90), meta.Name, cid, len(results), strings.Repeat("─", 90))
-	fmt.Printf(" %2s  %s  %6s  %7s  %9s  %s\n", "#", " ", "Ping", "Archive", "MaxRange", "URL")
-
-	for i, r := range results {
-		lat := "  —"
-		if r.LatencyMs > 0 {
-			lat = fmt.Sprintf("%4.0fms", r.LatencyMs)
+	if !opts.quiet {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		header := " #\t \tPing\tArchive\tMaxRange\t" + extraCells(nil)
+		fmt.Fprintf(tw, "%sURL\n", header)
+		// Detail lines go in the URL column so they keep the table aligned.
+		indent := strings.Repeat("\t", strings.Count(header, "\t"))
+		for i, r := range results {
+			lat := "—"
+			if r.LatencyMs > 0 {
+				lat = fmt.Sprintf("%.0fms", r.LatencyMs)
+			}
+			rng := "—"
+			if r.MaxRange > 0 {
+				rng = fmtInt(r.MaxRange)
+			}
+			short := strings.TrimPrefix(r.URL, "https://")
+			if r.CDNProxy {
+				short += "  [CDN]"
+			}
+			if r.slow() {
+				short += "  [SLOW]"
+			}
+			fmt.Fprintf(tw, " %d\t%s\t%s\t%s\t%s\t%s%s\n",
+				i+1, r.icon(), lat, yesNo(r.Archive), rng, extraCells(&r), short)
+			if opts.verbose {
+				printDetails(tw, indent, r)
+			}
 		}
-		arc := " NO"
+		tw.Flush()
+	}
+	if opts.ipinfo && slices.ContainsFunc(results, func(r result) bool { return r.SharedASN }) {
+		fmt.Println("  * shares an ASN with another endpoint of this chain (redundancy risk)")
+	}
+	fmt.Printf("  %s\n", stats)
+}
+
+// chainStats summarizes results on one line: archive share, mean archive
+// latency, and the median and best max range among archive endpoints. It
+// does not rely on sort order, which -score-weights changes, and allocates
+// nothing beyond the returned string.
+func chainStats(results []result) string {
+	k, best := 0, 0
+	var sumMs float64
+	for _, r := range results {
 		if r.Archive {
-			arc = "YES"
+			k++
+			sumMs += r.LatencyMs
+			best = max(best, r.MaxRange)
 		}
-		rng := "    —"
-		if r.MaxRange > 0 {
-			rng = fmt.Sprintf("%7s", fmtInt(r.MaxRange))
+	}
+	pct := 0
+	if len(results) > 0 {
+		pct = k * 100 / len(results)
+	}
+	s := fmt.Sprintf("%d tested · %d archive (%d%%)", len(results), k, pct)
+	if k == 0 {
+		return s
+	}
+	median := nthArchiveRange(results, k/2)
+	if k%2 == 0 {
+		median = (nthArchiveRange(results, k/2-1) + median) / 2
+	}
+	return fmt.Sprintf("%s · mean %.0fms · median range %s · best %s",
+		s, sumMs/float64(k), fmtInt(median), fmtInt(best))
+}
+
+// nthArchiveRange returns the n-th smallest (from 0) MaxRange among archive
+// results by counting in place; chains have few enough endpoints that the
+// quadratic scan beats sorting a copy.
+func nthArchiveRange(results []result, n int) int {
+	for _, a := range results {
+		if !a.Archive {
+			continue
+		}
+		less, equal := 0, 0
+		for _, b := range results {
+			switch {
+			case !b.Archive:
+			case b.MaxRange < a.MaxRange:
+				less++
+			case b.MaxRange == a.MaxRange:
+				equal++
+			}
+		}
+		if less <= n && n < less+equal {
+			return a.MaxRange
+		}
+	}
+	return 0
+}
+
+// bestArchive returns the highest-ranked archive endpoint of sorted results.
+// With -score-weights archive endpoints need not come first, so it does not
+// assume results[0] is one.
+func bestArchive(results []result) (result, bool) {
+	i := slices.IndexFunc(results, func(r result) bool { return r.Archive })
+	if i < 0 {
+		return result{}, false
+	}
+	return results[i], true
+}
+
+// printDetails writes the verbose lines below an endpoint's row, each
+// starting with indent.
+func printDetails(w io.Writer, indent string, r result) {
+	if r.ErrorMsg != "" {
+		if r.ErrorCode != 0 {
+			fmt.Fprintf(w, "%serror %d: %s\n", indent, r.ErrorCode, r.ErrorMsg)
+		} else {
+			fmt.Fprintf(w, "%serror: %s\n", indent, r.ErrorMsg)
+		}
+	}
+	for _, warn := range r.Warnings {
+		fmt.Fprintf(w, "%swarning: %s\n", indent, warn)
+	}
+	if len(r.BurstMs) > 0 {
+		fmt.Fprintf(w, "%sburst: %s\n", indent, fmtBurst(r.BurstMs))
+	}
+	if opts.checkJitter && r.Reachable {
+		fmt.Fprintf(w, "%sjitter: %.1fms\n", indent, r.LatencyJitterMs)
+	}
+	if r.Score > 0 {
+		fmt.Fprintf(w, "%sscore: %.1f (%s)\n", indent, r.Score, opts.weights.String())
+	}
+	if r.ASN != "" {
+		fmt.Fprintf(w, "%snetwork: %s (%s %s)\n", indent, r.IP, r.ASN, r.ASName)
+	}
+	if r.LogSubscribeNote != "" {
+		fmt.Fprintf(w, "%slog subscription: %s\n", indent, r.LogSubscribeNote)
+	}
+	if opts.checkPeerCount && r.Reachable {
+		switch {
+		case r.PeerCount < 0:
+			fmt.Fprintf(w, "%speers: unavailable\n", indent)
+		case r.PeerCountWarning:
+			fmt.Fprintf(w, "%speers: 0 (isolated, or topology hidden by the provider)\n", indent)
+		default:
+			fmt.Fprintf(w, "%speers: %d\n", indent, r.PeerCount)
 		}
-		short := strings.TrimPrefix(r.URL, "https://")
-		fmt.Printf(" %2d  %s  %6s  %7s  %9s  %s\n", i+1, r.icon(), lat, arc, rng, short)
 	}
+	if r.Client != "" {
+		fmt.Fprintf(w, "%sclient: %s\n", indent, r.Client)
+	}
+	if r.LatestBlock > 0 {
+		fmt.Fprintf(w, "%slatest block: %s\n", indent, fmtInt(int(r.LatestBlock)))
+	}
+	fmt.Fprintf(w, "%sdata: %s sent, %s received\n", indent, fmtBytes(r.BytesSent), fmtBytes(r.BytesRecv))
+	if opts.gzip && r.BytesRecv > 0 {
+		fmt.Fprintf(w, "%scompression: %s on the wire (%.0f%%)\n",
+			indent, fmtBytes(r.CompressedBytes), 100*float64(r.CompressedBytes)/float64(r.BytesRecv))
+	}
+}
+
+// fmtBurst renders -rpc-call-count latencies, "✗" marking failed calls.
+func fmtBurst(samples []float64) string {
+	parts := make([]string, len(samples))
+	for i, ms := range samples {
+		parts[i] = "✗"
+		if ms >= 0 {
+			parts[i] = fmt.Sprintf("%.0f", ms)
+		}
+	}
+	return strings.Join(parts, " ") + " ms"
+}
+
+// fmtBytes renders n in binary units (B, KiB, MiB, ...).
+func fmtBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	f, unit := float64(n)/1024, 0
+	for f >= 1024 && unit < 3 {
+		f /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %ciB", f, "KMGT"[unit])
 }
 
-func generateTOML(allResults map[uint64][]result) string {
+// generateTOML renders the ranked config, carrying over per-chain settings
+// from cfgs. Reachable endpoints are kept unless archiveOnly is set, in which
+// case non-archive endpoints are dropped too.
+func generateTOML(allResults map[uint64][]result, cfgs map[string]chainCfg, archiveOnly bool) string {
 	var b strings.Builder
 	b.WriteString("# ERC-8004 events sync configuration.\n")
 	b.WriteString("# RPC endpoints per chain, ordered by priority (best first).\n")
@@ -56,23 +364,379 @@ func generateTOML(allResults map[uint64][]result) string {
 		results := allResults[cid]
 		sortResults(results)
 		meta := chains[cid]
-		fmt.Fprintf(&b, "[chains.%d]  # %s\nrpcs = [\n", cid, meta.Name)
+		fmt.Fprintf(&b, "[chains.%d]  # %s\n", cid, meta.Name)
+		cc := cfgs[strconv.FormatUint(cid, 10)]
+		assignWeights(results, cc)
+		b.WriteString(cc.settingsTOML())
+		b.WriteString("rpcs = [\n")
+		drop := redundantTransports(results)
+		var weights []string
 		for _, r := range results {
-			if r.Reachable {
-				fmt.Fprintf(&b, "    %q,\n", r.URL)
+			if !recommended(r, archiveOnly, drop) {
+				continue
 			}
+			if r.Unstable && opts.reportUnstable {
+				fmt.Fprintf(&b, "    # %q,  # unstable: archive status changed since the last run\n", r.tomlURL())
+				continue
+			}
+			if isWebSocket(r.URL) {
+				fmt.Fprintf(&b, "    %q,  # ws\n", r.tomlURL())
+			} else {
+				fmt.Fprintf(&b, "    %q,\n", r.tomlURL())
+			}
+			weights = append(weights, strconv.Itoa(r.Weight))
+		}
+		b.WriteString("]\n")
+		if len(cc.Weights) > 0 {
+			fmt.Fprintf(&b, "weights = [%s]\n", strings.Join(weights, ", "))
 		}
-		b.WriteString("]\n\n")
+		b.WriteString("\n")
 	}
 	return b.String()
 }
 
+// recommended reports whether r belongs in the generated config: reachable,
+// archive-capable under archiveOnly, not dropped as a redundant transport,
+// not behind a CDN under -exclude-cdn and within -max-latency.
+func recommended(r result, archiveOnly bool, drop map[string]bool) bool {
+	return r.Reachable && (r.Archive || !archiveOnly) && !drop[r.URL] &&
+		!(r.CDNProxy && opts.excludeCDN) && !r.slow()
+}
+
+// maxWeight is the weight of a chain's best-scoring endpoint when weights
+// are derived from scores.
+const maxWeight = 10
+
+// assignWeights sets each result's Weight from the chain's configured
+// weights, matched by config URL, when there is one per RPC. Otherwise
+// weights scale Score so the best endpoint gets maxWeight and every
+// reachable one at least 1. Unreachable endpoints get 0.
+func assignWeights(results []result, cc chainCfg) {
+	if len(cc.Weights) > 0 && len(cc.Weights) == len(cc.RPCs) {
+		byURL := map[string]int{}
+		for i, u := range cc.RPCs {
+			byURL[u] = cc.Weights[i]
+		}
+		for i := range results {
+			results[i].Weight = byURL[results[i].tomlURL()]
+		}
+		return
+	}
+	var best float64
+	for _, r := range results {
+		if r.Reachable {
+			best = max(best, r.Score)
+		}
+	}
+	for i := range results {
+		r := &results[i]
+		switch {
+		case !r.Reachable:
+			r.Weight = 0
+		case best <= 0:
+			r.Weight = 1
+		default:
+			r.Weight = max(int(math.Round(maxWeight*r.Score/best)), 1)
+		}
+	}
+}
+
+// wsPreferMs is how much faster a host's WebSocket endpoint must be than its
+// HTTP one before the WebSocket endpoint is kept instead.
+const wsPreferMs = 100
+
+// redundantTransports returns the URLs to omit where one host is reachable
+// over both HTTP and WebSocket: the HTTP endpoints are kept unless they are
+// slower than the WebSocket ones by more than wsPreferMs.
+func redundantTransports(results []result) map[string]bool {
+	type best struct{ http, ws float64 }
+	hosts := map[string]*best{}
+	for _, r := range results {
+		if !r.Reachable {
+			continue
+		}
+		u, err := neturl.Parse(r.URL)
+		if err != nil {
+			continue
+		}
+		h := hosts[u.Hostname()]
+		if h == nil {
+			h = &best{math.Inf(1), math.Inf(1)}
+			hosts[u.Hostname()] = h
+		}
+		if isWebSocket(r.URL) {
+			h.ws = min(h.ws, r.LatencyMs)
+		} else {
+			h.http = min(h.http, r.LatencyMs)
+		}
+	}
+	drop := map[string]bool{}
+	for _, r := range results {
+		u, err := neturl.Parse(r.URL)
+		if err != nil {
+			continue
+		}
+		h := hosts[u.Hostname()]
+		if h == nil || math.IsInf(h.http, 1) || math.IsInf(h.ws, 1) {
+			continue
+		}
+		preferWS := h.http-h.ws > wsPreferMs
+		if isWebSocket(r.URL) != preferWS {
+			drop[r.URL] = true
+		}
+	}
+	return drop
+}
+
+// writeChainFiles writes each chain's results to dir/<chain_id>.json,
+// creating dir if needed.
+func writeChainFiles(dir string, allResults map[uint64][]result) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for cid, results := range allResults {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(dir, fmt.Sprintf("%d.json", cid)), append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resultsFile is the -output-json document.
+type resultsFile struct {
+	GeneratedAt time.Time           `json:"generated_at"`
+	Chains      map[uint64][]result `json:"chains"`
+}
+
+func generateJSON(allResults map[uint64][]result) ([]byte, error) {
+	data, err := json.MarshalIndent(resultsFile{time.Now().UTC(), allResults}, "", "  ")
+	return append(data, '\n'), err
+}
+
+// generateCSV renders one row per endpoint, chains in ID order and endpoints
+// ranked within each chain.
+func generateCSV(allResults map[uint64][]result) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write([]string{"chain_id", "chain", "url", "reachable", "latency_ms", "latest_block",
+		"archive", "logs", "max_range", "error"})
+	for _, cid := range slices.Sorted(maps.Keys(allResults)) {
+		results := allResults[cid]
+		sortResults(results)
+		for _, r := range results {
+			w.Write([]string{
+				strconv.FormatUint(cid, 10), chains[cid].Name, r.URL,
+				strconv.FormatBool(r.Reachable), strconv.FormatFloat(r.LatencyMs, 'f', -1, 64),
+				strconv.FormatUint(r.LatestBlock, 10), strconv.FormatBool(r.Archive),
+				strconv.Itoa(r.Logs), strconv.Itoa(r.MaxRange), r.ErrorMsg,
+			})
+		}
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+// writeOutputs renders and atomically writes each output file concurrently,
+// returning the first error.
+func writeOutputs(outputs map[string]func() ([]byte, error)) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(outputs))
+	for path, render := range outputs {
+		wg.Go(func() {
+			data, err := render()
+			if err == nil {
+				err = writeFileAtomic(path, data)
+			}
+			if err != nil {
+				errs <- fmt.Errorf("writing %s: %w", path, err)
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// backupFile copies path to path.<timestamp>.bak, then deletes all but the
+// keep most recent backups (keep <= 0 keeps them all).
+func backupFile(path string, keep int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	bak := fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102_150405"))
+	if err := writeFileAtomic(bak, data); err != nil {
+		return err
+	}
+	if keep <= 0 {
+		return nil
+	}
+	// The timestamp format sorts chronologically.
+	old, err := filepath.Glob(path + ".*_*.bak")
+	if err != nil {
+		return err
+	}
+	slices.Sort(old)
+	for _, f := range old[:max(len(old)-keep, 0)] {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never observe a partial file. An existing file keeps
+// its mode; new files, backups included, are created 0600 since configs may
+// hold credentials.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o600)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+type chainSummary struct {
+	Archive       int     `json:"archive"`
+	Reachable     int     `json:"reachable"`
+	BestLatencyMs float64 `json:"best_latency_ms"`
+	BestMaxRange  int     `json:"best_max_range"`
+}
+
+type runSummary struct {
+	Chains         map[uint64]chainSummary `json:"chains"`
+	TotalEndpoints int                     `json:"total_endpoints"`
+	DurationS      float64                 `json:"duration_s"`
+}
+
+// writeSummary emits a one-line JSON digest of the run for CI consumption.
+func writeSummary(w io.Writer, allResults map[uint64][]result, elapsed time.Duration) error {
+	sum := runSummary{Chains: make(map[uint64]chainSummary, len(allResults))}
+	for cid, results := range allResults {
+		var cs chainSummary
+		for _, r := range results {
+			if !r.Reachable {
+				continue
+			}
+			cs.Reachable++
+			if cs.Reachable == 1 || r.LatencyMs < cs.BestLatencyMs {
+				cs.BestLatencyMs = r.LatencyMs
+			}
+			if r.Archive {
+				cs.Archive++
+				cs.BestMaxRange = max(cs.BestMaxRange, r.MaxRange)
+			}
+		}
+		sum.Chains[cid] = cs
+		sum.TotalEndpoints += len(results)
+	}
+	sum.DurationS = math.Round(elapsed.Seconds()*10) / 10
+	return json.NewEncoder(w).Encode(sum)
+}
+
+// scoreWeights are the -score-weights multipliers of the composite score.
+type scoreWeights struct {
+	Archive, Range, Latency float64
+	set                     bool // given on the command line
+}
+
+var weightNames = []string{"archive", "range", "latency"}
+
+func (w *scoreWeights) field(name string) *float64 {
+	switch name {
+	case "archive":
+		return &w.Archive
+	case "range":
+		return &w.Range
+	case "latency":
+		return &w.Latency
+	}
+	return nil
+}
+
+func (w *scoreWeights) String() string {
+	var parts []string
+	for _, n := range weightNames {
+		parts = append(parts, n+"="+strconv.FormatFloat(*w.field(n), 'g', -1, 64))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (w *scoreWeights) Set(v string) error {
+	for _, kv := range strings.Split(v, ",") {
+		k, val, ok := strings.Cut(kv, "=")
+		p := w.field(strings.TrimSpace(k))
+		if !ok || p == nil {
+			return fmt.Errorf("want name=weight with name one of %s, got %q", strings.Join(weightNames, ", "), kv)
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil || f < 0 {
+			return fmt.Errorf("invalid weight %q", val)
+		}
+		*p = f
+	}
+	w.set = true
+	return nil
+}
+
+// score is the composite ranking score of a reachable endpoint:
+// archive·w + (MaxRange/1000)·w + (1000/max(rankLatency, 1))·w.
+func (r result) score(w scoreWeights) float64 {
+	if !r.Reachable {
+		return 0
+	}
+	var arc float64
+	if r.Archive {
+		arc = 1
+	}
+	return arc*w.Archive +
+		float64(r.MaxRange)/1000*w.Range +
+		1000/max(r.rankLatency(), 1)*w.Latency
+}
+
+// scoreOrder ranks higher scores first when -score-weights is given, and
+// otherwise defers to the tiered comparison in sortResults. The default
+// weights would let a fast non-archive node outrank slow archive ones, so the
+// score only drives sorting on request.
+func scoreOrder(a, b result) int {
+	if !opts.weights.set {
+		return 0
+	}
+	return cmp.Compare(b.Score, a.Score)
+}
+
+// sortResults ranks archive endpoints first, then reachable ones, then by
+// descending max range and ascending rankLatency. Ties keep their input
+// order.
 func sortResults(rs []result) {
-	slices.SortFunc(rs, func(a, b result) int {
+	slices.SortStableFunc(rs, func(a, b result) int {
 		return cmp.Or(
+			scoreOrder(a, b),
 			cmp.Compare(btoi(a.Archive), btoi(b.Archive)),
+			cmp.Compare(btoi(a.Reachable), btoi(b.Reachable)),
 			cmp.Compare(b.MaxRange, a.MaxRange),
-			cmp.Compare(a.LatencyMs, b.LatencyMs),
+			cmp.Compare(a.rankLatency(), b.rankLatency()),
 		)
 	})
 }
@@ -84,19 +748,49 @@ func btoi(b bool) int {
 	return 1
 }
 
-func fmtInt(n int) string {
+// fmtInt formats n with the thousands separator of the user's locale.
+func fmtInt(n int) string { return formatIntLocale(n, thousandsSep()) }
+
+// thousandsSep derives the grouping separator from LC_ALL, LC_NUMERIC or LANG
+// (in POSIX precedence order), defaulting to a comma.
+var thousandsSep = sync.OnceValue(func() rune {
+	var loc string
+	for _, k := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		if loc = os.Getenv(k); loc != "" {
+			break
+		}
+	}
+	lang, _, _ := strings.Cut(loc, "_")
+	lang, _, _ = strings.Cut(lang, ".")
+	switch strings.ToLower(lang) {
+	case "de", "da", "el", "es", "id", "it", "nl", "pt", "ro", "sl", "tr", "vi":
+		return '.'
+	case "cs", "fi", "fr", "hu", "nb", "no", "pl", "ru", "sk", "sv", "uk":
+		return ' '
+	default:
+		return ','
+	}
+})
+
+// formatIntLocale formats n with sep between groups of three digits.
+func formatIntLocale(n int, sep rune) string {
 	s := strconv.Itoa(n)
+	sign := ""
+	if n < 0 {
+		sign, s = "-", s[1:]
+	}
 	if len(s) <= 3 {
-		return s
+		return sign + s
 	}
 	var b strings.Builder
+	b.WriteString(sign)
 	pre := len(s) % 3
 	if pre > 0 {
 		b.WriteString(s[:pre])
 	}
 	for i := pre; i < len(s); i += 3 {
-		if b.Len() > 0 {
-			b.WriteByte(',')
+		if i > 0 {
+			b.WriteRune(sep)
 		}
 		b.WriteString(s[i : i+3])
 	}
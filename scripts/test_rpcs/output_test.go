@@ -0,0 +1,334 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func urls(rs []result) []string {
+	out := make([]string, len(rs))
+	for i, r := range rs {
+		out[i] = r.URL
+	}
+	return out
+}
+
+func TestSortResults(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []result
+		want []string
+	}{
+		{"empty", nil, []string{}},
+		{"single", []result{{URL: "a", Reachable: true}}, []string{"a"}},
+		{"archive beats non-archive", []result{
+			{URL: "plain", Reachable: true, LatencyMs: 10},
+			{URL: "archive", Reachable: true, Archive: true, LatencyMs: 900},
+		}, []string{"archive", "plain"}},
+		{"higher range wins among archive", []result{
+			{URL: "small", Reachable: true, Archive: true, MaxRange: 2_000, LatencyMs: 10},
+			{URL: "large", Reachable: true, Archive: true, MaxRange: 50_000, LatencyMs: 500},
+		}, []string{"large", "small"}},
+		{"lower latency breaks range tie", []result{
+			{URL: "slow", Reachable: true, Archive: true, MaxRange: 10_000, LatencyMs: 300},
+			{URL: "fast", Reachable: true, Archive: true, MaxRange: 10_000, LatencyMs: 40},
+		}, []string{"fast", "slow"}},
+		{"high jitter outweighs lower latency", []result{
+			{URL: "jittery", Reachable: true, Archive: true, MaxRange: 10_000, LatencyMs: 40, LatencyJitterMs: 150},
+			{URL: "steady", Reachable: true, Archive: true, MaxRange: 10_000, LatencyMs: 120, LatencyJitterMs: 20},
+		}, []string{"steady", "jittery"}},
+		{"unreachable sorts last", []result{
+			{URL: "down1"},
+			{URL: "plain", Reachable: true, LatencyMs: 250},
+			{URL: "down2"},
+			{URL: "archive", Reachable: true, Archive: true, MaxRange: 500, LatencyMs: 80},
+		}, []string{"archive", "plain", "down1", "down2"}},
+		{"identical results are stable", []result{
+			{URL: "a", Reachable: true, LatencyMs: 50},
+			{URL: "b", Reachable: true, LatencyMs: 50},
+			{URL: "c", Reachable: true, LatencyMs: 50},
+		}, []string{"a", "b", "c"}},
+		{"all archive", []result{
+			{URL: "r5k", Reachable: true, Archive: true, MaxRange: 5_000, LatencyMs: 20},
+			{URL: "r50k-slow", Reachable: true, Archive: true, MaxRange: 50_000, LatencyMs: 200},
+			{URL: "r50k-fast", Reachable: true, Archive: true, MaxRange: 50_000, LatencyMs: 100},
+		}, []string{"r50k-fast", "r50k-slow", "r5k"}},
+		{"none archive", []result{
+			{URL: "slow", Reachable: true, LatencyMs: 400},
+			{URL: "down"},
+			{URL: "fast", Reachable: true, LatencyMs: 90},
+		}, []string{"fast", "slow", "down"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := slices.Clone(tt.in)
+			sortResults(rs)
+			if got := urls(rs); !slices.Equal(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResultScore(t *testing.T) {
+	w := scoreWeights{Archive: 10, Range: 5, Latency: 1}
+	tests := []struct {
+		name string
+		r    result
+		want float64
+	}{
+		{"unreachable", result{}, 0},
+		{"archive", result{Reachable: true, Archive: true, MaxRange: 10_000, LatencyMs: 100}, 10 + 50 + 10},
+		{"sub-millisecond latency clamps to 1ms", result{Reachable: true, LatencyMs: 0}, 1000},
+	}
+	for _, tt := range tests {
+		if got := tt.r.score(w); got != tt.want {
+			t.Errorf("%s: score = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChainStatsScoreOrder(t *testing.T) {
+	// Score ordering can put a non-archive endpoint first.
+	rs := []result{
+		{URL: "fast", Reachable: true, LatencyMs: 10, MaxRange: 100},
+		{URL: "archive", Reachable: true, Archive: true, LatencyMs: 800, MaxRange: 500},
+		{URL: "small", Reachable: true, Archive: true, LatencyMs: 200, MaxRange: 300},
+	}
+	want := "3 tested · 2 archive (66%) · mean 500ms · median range 400 · best 500"
+	if got := chainStats(rs); got != want {
+		t.Errorf("chainStats = %q, want %q", got, want)
+	}
+	for _, tt := range []struct{ n, want int }{{0, 300}, {1, 500}} {
+		if got := nthArchiveRange(rs, tt.n); got != tt.want {
+			t.Errorf("nthArchiveRange(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+	}
+	if n := testing.AllocsPerRun(10, func() { nthArchiveRange(rs, 1) }); n != 0 {
+		t.Errorf("nthArchiveRange allocates %v times", n)
+	}
+	if best, ok := bestArchive(rs); !ok || best.URL != "archive" {
+		t.Errorf("bestArchive = %q, %v, want archive", best.URL, ok)
+	}
+	if _, ok := bestArchive(rs[:1]); ok {
+		t.Error("bestArchive found an archive endpoint among none")
+	}
+}
+
+func TestLatencyBucketsSet(t *testing.T) {
+	tests := []struct {
+		in   string
+		want latencyBuckets
+		ok   bool
+	}{
+		{"200,800", latencyBuckets{200, 800}, true},
+		{" 50 , 200.5 ", latencyBuckets{50, 200.5}, true},
+		{"200", latencyBuckets{}, false},
+		{"500,200", latencyBuckets{}, false},
+		{"0,200", latencyBuckets{}, false},
+		{"fast,slow", latencyBuckets{}, false},
+	}
+	for _, tt := range tests {
+		var b latencyBuckets
+		err := b.Set(tt.in)
+		if (err == nil) != tt.ok || (tt.ok && b != tt.want) {
+			t.Errorf("Set(%q) = %+v, %v", tt.in, b, err)
+		}
+	}
+}
+
+func TestGenerateTOMLRoundTrip(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          map[uint64][]result
+		archiveOnly bool
+		want        map[string][]string
+	}{
+		{"empty", map[uint64][]result{}, false, map[string][]string{}},
+		{"ranked and filtered", map[uint64][]result{
+			1: {
+				{URL: "https://plain.example", Reachable: true, LatencyMs: 30},
+				{URL: "https://down.example"},
+				{URL: "https://archive.example", Reachable: true, Archive: true, MaxRange: 500},
+			},
+			8453: {{URL: "https://base.example", Reachable: true, Archive: true}},
+		}, false, map[string][]string{
+			"1":    {"https://archive.example", "https://plain.example"},
+			"8453": {"https://base.example"},
+		}},
+		{"archive only", map[uint64][]result{
+			1: {
+				{URL: "https://plain.example", Reachable: true},
+				{URL: "https://archive.example", Reachable: true, Archive: true},
+			},
+		}, true, map[string][]string{"1": {"https://archive.example"}}},
+		{"no reachable endpoints", map[uint64][]result{
+			10: {{URL: "https://down.example"}},
+		}, false, map[string][]string{"10": {}}},
+		{"websocket endpoints", map[uint64][]result{
+			1: {
+				{URL: "https://both.example/rpc", Reachable: true, LatencyMs: 120},
+				{URL: "wss://both.example/ws", Reachable: true, LatencyMs: 80},
+				{URL: "wss://wsonly.example", Reachable: true, LatencyMs: 90},
+				{URL: "https://slow.example", Reachable: true, LatencyMs: 400},
+				{URL: "wss://slow.example", Reachable: true, LatencyMs: 100},
+			},
+		}, false, map[string][]string{
+			"1": {"wss://wsonly.example", "wss://slow.example", "https://both.example/rpc"},
+		}},
+		{"special characters", map[uint64][]result{
+			1: {{URL: `https://rpc.example/k="a\b"?q=ü#frag`, Reachable: true}},
+		}, false, map[string][]string{"1": {`https://rpc.example/k="a\b"?q=ü#frag`}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := generateTOML(tt.in, nil, tt.archiveOnly)
+			var cfg config
+			if _, err := toml.Decode(out, &cfg); err != nil {
+				t.Fatalf("decode: %v\n%s", err, out)
+			}
+			if len(cfg.Chains) != len(tt.want) {
+				t.Fatalf("chains = %v, want %v", cfg.Chains, tt.want)
+			}
+			for cid, want := range tt.want {
+				got, ok := cfg.Chains[cid]
+				if !ok {
+					t.Fatalf("chain %s missing from output:\n%s", cid, out)
+				}
+				if !slices.Equal(got.RPCs, want) && len(got.RPCs)+len(want) > 0 {
+					t.Errorf("chain %s rpcs = %q, want %q", cid, got.RPCs, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBackupFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	os.WriteFile(path, []byte("current"), 0o644)
+	for _, ts := range []string{"20240101_000000", "20240102_000000", "20240103_000000"} {
+		os.WriteFile(path+"."+ts+".bak", []byte(ts), 0o644)
+	}
+
+	if err := backupFile(path, 2); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := filepath.Glob(path + ".*.bak")
+	if len(got) != 2 || filepath.Base(got[0]) != "config.toml.20240103_000000.bak" {
+		t.Fatalf("backups = %v, want the 2024-01-03 one and the new one", got)
+	}
+	if data, _ := os.ReadFile(got[1]); string(data) != "current" {
+		t.Errorf("new backup = %q, want %q", data, "current")
+	}
+}
+
+func TestWriteFileAtomicMode(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "config.toml")
+	os.WriteFile(existing, []byte("old"), 0o640)
+	os.Chmod(existing, 0o640) // bypass the umask
+	fresh := filepath.Join(dir, "new.toml")
+	for path, want := range map[string]os.FileMode{existing: 0o640, fresh: 0o600} {
+		if err := writeFileAtomic(path, []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fi.Mode().Perm(); got != want {
+			t.Errorf("%s: mode = %v, want %v", filepath.Base(path), got, want)
+		}
+	}
+}
+
+func TestDiffResultFiles(t *testing.T) {
+	a := resultsFile{Chains: map[uint64][]result{1: {
+		{URL: "steady", Reachable: true, Archive: true, LatencyMs: 100, MaxRange: 500},
+		{URL: "slower", Reachable: true, Archive: true, LatencyMs: 100, MaxRange: 500},
+		{URL: "down", Reachable: true, LatencyMs: 50},
+		{URL: "gone", Reachable: true},
+	}}}
+	b := resultsFile{Chains: map[uint64][]result{
+		1: {
+			{URL: "steady", Reachable: true, Archive: true, LatencyMs: 115, MaxRange: 520},
+			{URL: "slower", Reachable: true, Archive: true, LatencyMs: 130, MaxRange: 200},
+			{URL: "down"},
+		},
+		10: {{URL: "new", Reachable: true, Archive: true}},
+	}}
+	want := []endpointChange{
+		{1, "down", "status", "non-archive", "unreachable"},
+		{1, "gone", "only_a", "non-archive", ""},
+		{1, "slower", "latency", "100ms", "130ms"},
+		{1, "slower", "max_range", "500", "200"},
+		{10, "new", "only_b", "", "archive"},
+	}
+	if got := diffResultFiles(a, b); !slices.Equal(got, want) {
+		t.Errorf("diffResultFiles =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestAssignWeights(t *testing.T) {
+	results := []result{
+		{URL: "a", Reachable: true, Score: 80},
+		{URL: "b", Reachable: true, Score: 20},
+		{URL: "c", Reachable: true, Score: 1},
+		{URL: "d"},
+	}
+	weights := func() []int {
+		var w []int
+		for _, r := range results {
+			w = append(w, r.Weight)
+		}
+		return w
+	}
+
+	assignWeights(results, chainCfg{RPCs: []string{"a", "b", "c", "d"}})
+	if got, want := weights(), []int{10, 3, 1, 0}; !slices.Equal(got, want) {
+		t.Errorf("from scores: weights = %v, want %v", got, want)
+	}
+	assignWeights(results, chainCfg{RPCs: []string{"d", "c", "b", "a"}, Weights: []int{4, 3, 2, 1}})
+	if got, want := weights(), []int{1, 2, 3, 4}; !slices.Equal(got, want) {
+		t.Errorf("from config: weights = %v, want %v", got, want)
+	}
+}
+
+func TestUpstreamAddr(t *testing.T) {
+	tests := []struct {
+		url, want string
+		ok        bool
+	}{
+		{"https://rpc.example.com", "rpc.example.com:443", true},
+		{"http://10.0.0.1:8545/", "10.0.0.1:8545", true},
+		{"https://rpc.example.com/v1/KEY", "", false},
+		{"https://rpc.example.com?key=1", "", false},
+		{"wss://rpc.example.com", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := upstreamAddr(tt.url); got != tt.want || ok != tt.ok {
+			t.Errorf("upstreamAddr(%q) = %q, %v", tt.url, got, ok)
+		}
+	}
+}
+
+func TestResultColumnsUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for _, name := range baseColumns {
+		seen[name] = true
+	}
+	for _, c := range resultColumns() {
+		if seen[c.name] {
+			t.Errorf("column %s appears twice", c.name)
+		}
+		seen[c.name] = true
+	}
+	if !seen["reported_chain_id"] {
+		t.Error("result.ChainID has no reported_chain_id column")
+	}
+}
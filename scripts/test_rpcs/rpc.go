@@ -2,15 +2,132 @@ package main
 
 import (
 	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"mime"
+	"net"
 	"net/http"
+	neturl "net/url"
+	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var client = &http.Client{Timeout: 20 * time.Second}
+// transport is shared by client and slowClient so that the calls of an
+// endpoint's test sequence reuse one connection. Closing a connection per
+// call leaves a socket in TIME_WAIT for each, which on a large run can
+// exhaust the ephemeral port range.
+var transport = newTransport()
+
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = dialContext
+	t.DisableKeepAlives = false
+	t.IdleConnTimeout = 30 * time.Second
+	t.MaxIdleConnsPerHost = 3
+	return t
+}
+
+// resolver serves the -dns-timeout lookups; tests replace it.
+var resolver = net.DefaultResolver
+
+var dialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+// dialContext resolves the host under -dns-timeout, when set, before
+// dialing, so a hanging resolver fails fast instead of eating the whole
+// request timeout.
+func dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || opts.dnsTimeout <= 0 || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	lctx, cancel := context.WithTimeout(ctx, opts.dnsTimeout)
+	defer cancel()
+	ips, err := resolver.LookupIPAddr(lctx, host)
+	if err != nil {
+		if lctx.Err() != nil && ctx.Err() == nil {
+			return nil, &DNSTimeoutError{host, opts.dnsTimeout}
+		}
+		return nil, err
+	}
+	for _, ip := range ips {
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// DNSTimeoutError reports a host that did not resolve within -dns-timeout.
+type DNSTimeoutError struct {
+	Host    string
+	Timeout time.Duration
+}
+
+func (e *DNSTimeoutError) Error() string {
+	return fmt.Sprintf("DNS timeout: %s did not resolve within %s", e.Host, e.Timeout)
+}
+
+var client = &http.Client{Timeout: 20 * time.Second, Transport: transport}
+
+// slowClient serves calls that are expensive by design, such as replay
+// traces.
+var slowClient = &http.Client{Timeout: 60 * time.Second, Transport: transport}
+
+// traceTransport logs the headers of every request and response to w for
+// -trace-http. Authorization values are redacted and bodies are not logged.
+type traceTransport struct {
+	base http.RoundTripper
+	w    io.Writer
+}
+
+// redactedHeaders are logged by name only.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization"}
+
+func (t traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h := req.Header.Clone()
+	for _, k := range redactedHeaders {
+		if h.Get(k) != "" {
+			h.Set(k, "[redacted]")
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "> %s %s\n", req.Method, displayURL(req.URL.String()))
+	writeHeaders(&b, "> ", h)
+	t.w.Write(b.Bytes())
+
+	resp, err := t.base.RoundTrip(req)
+	b.Reset()
+	if err != nil {
+		fmt.Fprintf(&b, "< %s: %v\n", displayURL(req.URL.String()), err)
+	} else {
+		fmt.Fprintf(&b, "< %s %s\n", resp.Status, displayURL(req.URL.String()))
+		writeHeaders(&b, "< ", resp.Header)
+	}
+	t.w.Write(b.Bytes())
+	return resp, err
+}
+
+func writeHeaders(b *bytes.Buffer, prefix string, h http.Header) {
+	for _, k := range slices.Sorted(maps.Keys(h)) {
+		for _, v := range h[k] {
+			fmt.Fprintf(b, "%s%s: %s\n", prefix, k, v)
+		}
+	}
+}
 
 type rpcReq struct {
 	JSONRPC string `json:"jsonrpc"`
@@ -20,8 +137,9 @@ type rpcReq struct {
 }
 
 type rpcResp struct {
-	Result json.RawMessage `json:"result"`
-	Error  *rpcError       `json:"error"`
+	JSONRPC string          `json:"jsonrpc"` // checked only under -strict-json-rpc
+	Result  json.RawMessage `json:"result"`
+	Error   *rpcError       `json:"error"`
 }
 
 type rpcError struct {
@@ -29,31 +147,359 @@ type rpcError struct {
 	Message string `json:"message"`
 }
 
+// nextID yields the JSON-RPC request ID for each call; see setIDStrategy.
+var nextID = func() int { return 1 }
+
+// setIDStrategy selects how request IDs are assigned: "sequential" (a shared
+// counter), "random" (a fresh 31-bit value per call) or "fixed[=N]" (always
+// N, default 1).
+func setIDStrategy(s string) error {
+	name, arg, hasArg := strings.Cut(s, "=")
+	switch {
+	case name == "sequential" && !hasArg:
+		var seq atomic.Int64
+		nextID = func() int { return int(seq.Add(1)) }
+	case name == "random" && !hasArg:
+		nextID = func() int { return int(rand.Int32()) }
+	case name == "fixed":
+		id := 1
+		if hasArg {
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("invalid fixed ID %q", arg)
+			}
+			id = n
+		}
+		nextID = func() int { return id }
+	default:
+		return fmt.Errorf("unknown ID strategy %q (want sequential, random or fixed[=N])", s)
+	}
+	return nil
+}
+
+// endpointTraffic accumulates the bytes exchanged with one endpoint, and
+// whether any of its responses came through Cloudflare.
+type endpointTraffic struct {
+	sent, recv atomic.Int64
+	wire       atomic.Int64 // response bytes as transferred, before gunzip
+	cdn        atomic.Bool
+}
+
+// traffic maps endpoint URL to its *endpointTraffic for the current test.
+var traffic sync.Map
+
+func trafficFor(url string) *endpointTraffic {
+	t, _ := traffic.LoadOrStore(url, new(endpointTraffic))
+	return t.(*endpointTraffic)
+}
+
 func rpcCall(url, method string, params []any) (*rpcResp, time.Duration, error) {
-	body, _ := json.Marshal(rpcReq{"2.0", 1, method, params})
+	return rpcCallVia(client, url, method, params)
+}
+
+// rpcCallVia is rpcCall over a specific client, for calls that need a
+// different timeout.
+func rpcCallVia(c *http.Client, url, method string, params []any) (*rpcResp, time.Duration, error) {
+	body, _ := json.Marshal(rpcReq{"2.0", nextID(), method, params})
+	tr := trafficFor(url)
+	if lim := opts.maxBytes; lim > 0 && tr.sent.Load()+tr.recv.Load() >= lim {
+		return nil, 0, fmt.Errorf("byte budget of %d exceeded", lim)
+	}
+	var rep reply
+	var err error
+	for attempt := 0; ; attempt++ {
+		tr.sent.Add(int64(len(body)))
+		rep, err = roundTrip(c, url, body)
+		tr.recv.Add(int64(len(rep.data)))
+		tr.wire.Add(cmp.Or(rep.wireBytes, int64(len(rep.data))))
+		if rep.cdn {
+			tr.cdn.Store(true)
+		}
+		if rpcLog != nil {
+			logCall(url, body, rep, err)
+		}
+		if rep.status != http.StatusTooManyRequests || attempt >= retryPolicy.MaxRetries {
+			break
+		}
+		time.Sleep(retryPolicy.wait(rep.retryAfter))
+	}
+	if err != nil {
+		return nil, rep.elapsed, redactErr(err)
+	}
+	var r rpcResp
+	if err := json.Unmarshal(rep.data, &r); err != nil {
+		return nil, rep.elapsed, err
+	}
+	if opts.strictJSONRPC && r.JSONRPC != "2.0" {
+		return nil, rep.elapsed, fmt.Errorf("invalid jsonrpc version: %s", cmp.Or(r.JSONRPC, "missing"))
+	}
+	return &r, rep.elapsed, nil
+}
+
+const defaultMaxResponse = 10 << 20
+
+// responseLimit is the -max-response-size cap, defaulting to 10 MiB.
+func responseLimit() int64 {
+	if opts.maxResponse > 0 {
+		return int64(opts.maxResponse)
+	}
+	return defaultMaxResponse
+}
+
+// ResponseTooLargeError reports a response body over -max-response-size.
+type ResponseTooLargeError struct{ Limit int64 }
+
+func (e *ResponseTooLargeError) Error() string { return "response too large" }
+
+// reply is the raw outcome of one round trip.
+type reply struct {
+	data       []byte
+	status     int           // HTTP status code; 0 over WebSocket
+	elapsed    time.Duration // time to first response
+	retryAfter time.Duration // from a 429's Retry-After header; 0 if absent
+	cdn        bool          // the response carried a Cf-Ray header
+	wireBytes  int64         // gzip-encoded body size; 0 when not compressed
+}
+
+// RetryPolicy bounds how rpcCall retries HTTP 429 responses. Each retry waits
+// for the server's Retry-After, or Backoff without one, capped at MaxWait.
+type RetryPolicy struct {
+	MaxRetries int
+	Backoff    time.Duration
+	MaxWait    time.Duration
+}
+
+var retryPolicy = RetryPolicy{MaxRetries: 2, Backoff: 500 * time.Millisecond, MaxWait: 30 * time.Second}
+
+func (p RetryPolicy) wait(retryAfter time.Duration) time.Duration {
+	return min(cmp.Or(retryAfter, p.Backoff), p.MaxWait)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date.
+func parseRetryAfter(h string) time.Duration {
+	if secs, err := strconv.Atoi(h); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// newRPCRequest builds the HTTP POST for body, gzip-compressing it and
+// asking for a gzip response under -gzip.
+//
+// Credentials in the URL's userinfo are sent as a Basic Authorization header
+// and removed from the request URL, so they never reach logs or traces.
+func newRPCRequest(url string, body []byte) (*http.Request, error) {
+	if opts.gzip {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write(body)
+		zw.Close()
+		body = b.Bytes()
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if user := req.URL.User; user != nil {
+		pass, _ := user.Password()
+		req.SetBasicAuth(user.Username(), pass)
+		req.URL.User = nil
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if opts.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	return req, nil
+}
+
+// roundTrip sends one encoded request over HTTP or, for ws:// and wss://
+// URLs, a WebSocket.
+func roundTrip(c *http.Client, url string, body []byte) (reply, error) {
+	if isWebSocket(url) {
+		return wsRoundTrip(url, body, c.Timeout)
+	}
+	req, err := newRPCRequest(url, body)
+	if err != nil {
+		return reply{}, err
+	}
 	t0 := time.Now()
-	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
-	elapsed := time.Since(t0)
+	resp, err := c.Do(req)
+	rep := reply{elapsed: time.Since(t0)}
+	if dnsErr := (*DNSTimeoutError)(nil); errors.As(err, &dnsErr) {
+		return rep, dnsErr
+	}
 	if err != nil {
-		return nil, elapsed, err
+		return rep, err
 	}
 	defer resp.Body.Close()
+	rep.status = resp.StatusCode
+	rep.cdn = resp.Header.Get("Cf-Ray") != ""
+	if resp.StatusCode == http.StatusTooManyRequests {
+		rep.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, elapsed, fmt.Errorf("HTTP %d", resp.StatusCode)
+		return rep, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if opts.checkContentType {
+		// Proxies may answer 200 with an HTML or plain-text error page.
+		ct := resp.Header.Get("Content-Type")
+		if mt, _, _ := mime.ParseMediaType(ct); mt != "application/json" {
+			return rep, fmt.Errorf("wrong Content-Type: %s", cmp.Or(mt, ct, "none"))
+		}
+	}
+	var rd io.Reader = resp.Body
+	var wire *countingReader
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		wire = &countingReader{r: resp.Body}
+		zr, err := gzip.NewReader(wire)
+		if err != nil {
+			return rep, err
+		}
+		defer zr.Close()
+		rd = zr
+	}
+	// The limit applies after decompression.
+	limit := responseLimit()
+	rep.data, err = io.ReadAll(io.LimitReader(rd, limit+1))
+	if wire != nil {
+		rep.wireBytes = wire.n
+	}
+	if int64(len(rep.data)) > limit {
+		rep.data = rep.data[:limit]
+		return rep, &ResponseTooLargeError{limit}
+	}
+	return rep, err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// rpcLog receives one JSON line per call when -log-rpc-calls is set. Each
+// line goes out in a single Write on an O_APPEND file, so concurrent calls
+// do not interleave.
+var rpcLog *os.File
+
+type callLogEntry struct {
+	Timestamp     time.Time       `json:"timestamp"`
+	URL           string          `json:"url"`
+	Request       json.RawMessage `json:"request"`
+	ResponseBytes int             `json:"response_bytes"`
+	Response      string          `json:"response,omitempty"` // first 512 bytes
+	DurationMs    float64         `json:"duration_ms"`
+	StatusCode    int             `json:"status_code"`
+	Error         string          `json:"error,omitempty"`
+}
+
+func logCall(url string, body []byte, rep reply, err error) {
+	e := callLogEntry{
+		Timestamp:     time.Now().UTC(),
+		URL:           displayURL(url),
+		Request:       body,
+		ResponseBytes: len(rep.data),
+		Response:      truncate(string(rep.data), 512),
+		DurationMs:    float64(rep.elapsed.Microseconds()) / 1000,
+		StatusCode:    rep.status,
 	}
-	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, elapsed, err
+		e.Error = redactEnv(err.Error())
 	}
-	var r rpcResp
-	if err := json.Unmarshal(data, &r); err != nil {
-		return nil, elapsed, err
+	line, _ := json.Marshal(e)
+	rpcLog.Write(append(line, '\n'))
+}
+
+// stripUserinfo removes any user:password@ from an endpoint URL. Unparseable
+// URLs are returned unchanged.
+func stripUserinfo(rawURL string) string {
+	u, err := neturl.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
 	}
-	return &r, elapsed, nil
+	u.User = nil
+	return u.String()
+}
+
+// addBlocks returns n+span, saturating at the largest block number instead of
+// wrapping around.
+func addBlocks(n, span uint64) uint64 {
+	if n > math.MaxUint64-span {
+		return math.MaxUint64
+	}
+	return n + span
 }
 
 func toHex(n uint64) string { return "0x" + strconv.FormatUint(n, 16) }
 
+// hexUint is a JSON hex quantity such as "0x1a".
+type hexUint uint64
+
+func (h *hexUint) UnmarshalJSON(b []byte) error {
+	n, ok := parseHexUint(b)
+	if !ok {
+		return fmt.Errorf("invalid quantity %s", b)
+	}
+	*h = hexUint(n)
+	return nil
+}
+
+// blockHeader holds the eth_getBlockByNumber fields the checks inspect.
+type blockHeader struct {
+	Number        hexUint  `json:"number"`
+	Hash          string   `json:"hash"`
+	ParentHash    string   `json:"parentHash"`
+	BaseFeePerGas hexUint  `json:"baseFeePerGas"` // zero before EIP-1559
+	Transactions  []string `json:"transactions"`  // hashes, as fetchBlock omits bodies
+}
+
+// fetchBlock calls eth_getBlockByNumber without transactions. A nil header
+// with a nil error means the node returned null.
+func fetchBlock(url, tag string) (*blockHeader, *rpcError) {
+	return fetchBlockVia(url, "eth_getBlockByNumber", tag)
+}
+
+// fetchBlockByHash is fetchBlock for eth_getBlockByHash.
+func fetchBlockByHash(url, hash string) (*blockHeader, *rpcError) {
+	return fetchBlockVia(url, "eth_getBlockByHash", hash)
+}
+
+func fetchBlockVia(url, method, id string) (*blockHeader, *rpcError) {
+	r, _, err := rpcCall(url, method, []any{id, false})
+	if err != nil {
+		return nil, failure(err.Error())
+	}
+	if r.Error != nil {
+		return nil, r.Error
+	}
+	var b *blockHeader
+	if err := json.Unmarshal(r.Result, &b); err != nil {
+		return nil, failure("invalid block: " + err.Error())
+	}
+	return b, nil
+}
+
+// parseHexUint decodes a JSON hex quantity such as "0x1a".
+func parseHexUint(raw json.RawMessage) (uint64, bool) {
+	var s string
+	if json.Unmarshal(raw, &s) != nil || !strings.HasPrefix(s, "0x") {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s[2:], 16, 64)
+	return n, err == nil
+}
+
 func logFilter(from, to uint64) []any {
 	return []any{map[string]string{
 		"address":   identityAddr,
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRPCCall(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		wantErr string // substring; empty means success
+		minDur  time.Duration
+	}{
+		{name: "valid response", handler: func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+		}},
+		{name: "slow valid response", handler: func(w http.ResponseWriter, _ *http.Request) {
+			time.Sleep(25 * time.Millisecond)
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+		}, minDur: 25 * time.Millisecond},
+		{name: "invalid JSON", handler: func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte(`{"result":`))
+		}, wantErr: "unexpected end of JSON input"},
+		{name: "HTTP 404", handler: func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}, wantErr: "HTTP 404"},
+		{name: "HTTP 429", handler: func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}, wantErr: "HTTP 429"},
+		{name: "connection closed", handler: func(w http.ResponseWriter, _ *http.Request) {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+		}, wantErr: "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			r, d, err := rpcCall(srv.URL, "eth_blockNumber", []any{})
+			if d < tt.minDur {
+				t.Errorf("duration = %v, want >= %v", d, tt.minDur)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("err = %v", err)
+				}
+				if string(r.Result) != `"0x10"` || r.Error != nil {
+					t.Errorf("resp = %s %+v", r.Result, r.Error)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+			if r != nil {
+				t.Errorf("resp = %+v, want nil on error", r)
+			}
+		})
+	}
+}
+
+func TestRPCCallRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	r, _, err := rpcCall(srv.URL, "eth_blockNumber", []any{})
+	if err != nil || string(r.Result) != `"0x10"` {
+		t.Fatalf("rpcCall = %+v, %v; want success after retry", r, err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("calls = %d, want 2", calls.Load())
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("waited %v, want >= Retry-After of 1s", waited)
+	}
+}
+
+func TestRetryPolicyWait(t *testing.T) {
+	p := RetryPolicy{Backoff: time.Second, MaxWait: 30 * time.Second}
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"2", 2 * time.Second},
+		{"", time.Second},
+		{"soon", time.Second},
+		{"3600", 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.wait(parseRetryAfter(tt.header)); got != tt.want {
+			t.Errorf("wait(Retry-After %q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestRPCCallGzip(t *testing.T) {
+	opts.gzip = true
+	t.Cleanup(func() { opts.gzip = false })
+	payload := `{"jsonrpc":"2.0","id":1,"result":"0x10"}`
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(payload))
+	zw.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" || r.Header.Get("Accept-Encoding") != "gzip" {
+			http.Error(w, "want gzip", http.StatusBadRequest)
+			return
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req rpcReq
+		if err := json.NewDecoder(zr).Decode(&req); err != nil || req.Method != "eth_blockNumber" {
+			http.Error(w, "bad body", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed.Bytes())
+	}))
+	defer srv.Close()
+
+	traffic.Store(srv.URL, new(endpointTraffic))
+	r, _, err := rpcCall(srv.URL, "eth_blockNumber", []any{})
+	if err != nil || string(r.Result) != `"0x10"` {
+		t.Fatalf("rpcCall = %+v, %v", r, err)
+	}
+	tr := trafficFor(srv.URL)
+	if got, want := tr.wire.Load(), int64(compressed.Len()); got != want {
+		t.Errorf("wire bytes = %d, want %d", got, want)
+	}
+	if got, want := tr.recv.Load(), int64(len(payload)); got != want {
+		t.Errorf("received bytes = %d, want %d", got, want)
+	}
+}
+
+func TestTraceTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Served-By", "node-1")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer srv.Close()
+
+	var log strings.Builder
+	c := &http.Client{Transport: traceTransport{http.DefaultTransport, &log}}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("secret-body"))
+	req.Header.Set("Authorization", "Bearer secret-token")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	got := log.String()
+	for _, want := range []string{"> POST " + srv.URL, "> Authorization: [redacted]", "< 200 OK", "< X-Served-By: node-1"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("trace missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "secret") {
+		t.Errorf("trace leaks credentials or body:\n%s", got)
+	}
+}
+
+func TestRPCCallBasicAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "alice" || pass != "s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer srv.Close()
+
+	var trace strings.Builder
+	c := &http.Client{Transport: traceTransport{http.DefaultTransport, &trace}}
+	url := strings.Replace(srv.URL, "http://", "http://alice:s3cret@", 1)
+	r, _, err := rpcCallVia(c, url, "eth_blockNumber", []any{})
+	if err != nil || string(r.Result) != `"0x10"` {
+		t.Fatalf("rpcCall = %+v, %v", r, err)
+	}
+	if strings.Contains(trace.String(), "s3cret") || strings.Contains(trace.String(), "alice") {
+		t.Errorf("trace leaks credentials:\n%s", trace.String())
+	}
+	if got := stripUserinfo(url); got != srv.URL {
+		t.Errorf("stripUserinfo = %q, want %q", got, srv.URL)
+	}
+}
+
+func TestRPCCallWebSocketBasicAuth(t *testing.T) {
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "alice" || pass != "s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.ReadMessage()
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer srv.Close()
+
+	url := strings.Replace(srv.URL, "http://", "ws://alice:s3cret@", 1)
+	r, _, err := rpcCall(url, "eth_blockNumber", []any{})
+	if err != nil || string(r.Result) != `"0x10"` {
+		t.Fatalf("rpcCall = %+v, %v", r, err)
+	}
+}
+
+func TestRPCCallDNSTimeout(t *testing.T) {
+	opts.dnsTimeout = 50 * time.Millisecond
+	resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+	t.Cleanup(func() { opts.dnsTimeout, resolver = 0, net.DefaultResolver })
+
+	t0 := time.Now()
+	_, _, err := rpcCall("http://rpc.example.invalid:8545", "eth_blockNumber", []any{})
+	var dnsErr *DNSTimeoutError
+	if !errors.As(err, &dnsErr) || !strings.HasPrefix(err.Error(), "DNS timeout: ") {
+		t.Fatalf("err = %v, want a DNS timeout", err)
+	}
+	if d := time.Since(t0); d > 2*time.Second {
+		t.Errorf("took %v", d)
+	}
+}
+
+func TestRPCCallRedactsEnv(t *testing.T) {
+	t.Setenv("TEST_RPC_KEY", "SUPERSECRET")
+	u := deadURL(t) + "/v3/${TEST_RPC_KEY}"
+	expanded := expandURL(u)
+	if !strings.Contains(expanded, "SUPERSECRET") {
+		t.Fatalf("expandURL(%q) = %q", u, expanded)
+	}
+	_, _, err := rpcCall(expanded, "eth_blockNumber", []any{})
+	if err == nil || strings.Contains(err.Error(), "SUPERSECRET") || !strings.Contains(err.Error(), u) {
+		t.Errorf("err = %v, want the config URL %s", err, u)
+	}
+	if got := displayURL(expanded); got != u {
+		t.Errorf("displayURL = %q, want %q", got, u)
+	}
+}
+
+func TestMetaCacheRedacts(t *testing.T) {
+	t.Setenv("TEST_META_KEY", "SUPERSECRET")
+	expanded := expandURL("https://user:pw@rpc.example.com/v3/${TEST_META_KEY}")
+	c := &metaCache{entries: map[string]endpointMeta{}}
+	c.put(expanded, endpointMeta{ChainID: 1})
+	if _, ok := c.get(expanded); !ok {
+		t.Fatal("get missed the entry put under the same URL")
+	}
+	path := filepath.Join(t.TempDir(), "meta.json")
+	if err := c.save(path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "SUPERSECRET") || strings.Contains(string(data), "pw@") {
+		t.Errorf("cache file leaks credentials:\n%s", data)
+	}
+}
+
+func TestRPCCallContentType(t *testing.T) {
+	opts.checkContentType = true
+	t.Cleanup(func() { opts.checkContentType = false })
+	tests := []struct {
+		name, contentType, wantErr string
+	}{
+		{"json", "application/json", ""},
+		{"json with charset", "application/json; charset=utf-8", ""},
+		{"html error page", "text/html; charset=utf-8", "wrong Content-Type: text/html"},
+		{"plain text", "text/plain", "wrong Content-Type: text/plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+			}))
+			defer srv.Close()
+			_, _, err := rpcCall(srv.URL, "eth_blockNumber", []any{})
+			if got := fmt.Sprint(err); (tt.wantErr == "" && err != nil) || (tt.wantErr != "" && got != tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRPCCallStrictJSONRPC(t *testing.T) {
+	opts.strictJSONRPC = true
+	t.Cleanup(func() { opts.strictJSONRPC = false })
+	tests := []struct {
+		body, wantErr string
+	}{
+		{`{"jsonrpc":"2.0","id":1,"result":"0x10"}`, ""},
+		{`{"jsonrpc":"1.0","id":1,"result":"0x10"}`, "invalid jsonrpc version: 1.0"},
+		{`{"id":1,"result":"0x10"}`, "invalid jsonrpc version: missing"},
+	}
+	for _, tt := range tests {
+		_, _, err := rpcCall(rawServer(t, 200, tt.body), "eth_blockNumber", []any{})
+		if got := fmt.Sprint(err); (tt.wantErr == "" && err != nil) || (tt.wantErr != "" && got != tt.wantErr) {
+			t.Errorf("%s: err = %v, want %q", tt.body, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRPCCallRPCError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"limit exceeded"}}`))
+	}))
+	defer srv.Close()
+
+	r, _, err := rpcCall(srv.URL, "eth_getLogs", logFilter(1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Error == nil || r.Error.Code != -32005 || r.Error.Message != "limit exceeded" {
+		t.Errorf("Error = %+v", r.Error)
+	}
+}
+
+func TestRPCCallTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	old := client
+	client = &http.Client{Timeout: 50 * time.Millisecond}
+	defer func() { client = old }()
+
+	r, d, err := rpcCall(srv.URL, "eth_blockNumber", []any{})
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("err = %v, want a timeout", err)
+	}
+	if r != nil {
+		t.Errorf("resp = %+v, want nil", r)
+	}
+	if d < 50*time.Millisecond || d > 5*time.Second {
+		t.Errorf("duration = %v, want about the client timeout", d)
+	}
+}
+
+func FuzzToHex(f *testing.F) {
+	for _, n := range []uint64{0, 1, 15, 16, 24_339_871, math.MaxUint32, math.MaxUint64} {
+		f.Add(n)
+	}
+	f.Fuzz(func(t *testing.T, n uint64) {
+		s := toHex(n)
+		if !strings.HasPrefix(s, "0x") {
+			t.Fatalf("toHex(%d) = %q, missing 0x prefix", n, s)
+		}
+		got, err := strconv.ParseUint(s[2:], 16, 64)
+		if err != nil || got != n {
+			t.Fatalf("toHex(%d) = %q, parses back to %d (%v)", n, s, got, err)
+		}
+	})
+}
+
+func FuzzLogFilter(f *testing.F) {
+	f.Add(uint64(0), uint64(0))
+	f.Add(uint64(24_339_871), uint64(50_000))
+	f.Add(uint64(math.MaxUint64-10), uint64(100))
+	f.Add(uint64(math.MaxUint64), uint64(math.MaxUint64))
+	f.Fuzz(func(t *testing.T, from, span uint64) {
+		params := logFilter(from, addBlocks(from, span))
+		m := params[0].(map[string]string)
+		if m["address"] != identityAddr {
+			t.Fatalf("address = %q", m["address"])
+		}
+		lo, err1 := strconv.ParseUint(strings.TrimPrefix(m["fromBlock"], "0x"), 16, 64)
+		hi, err2 := strconv.ParseUint(strings.TrimPrefix(m["toBlock"], "0x"), 16, 64)
+		if err1 != nil || err2 != nil {
+			t.Fatalf("unparseable filter %v", m)
+		}
+		if lo != from || lo > hi {
+			t.Fatalf("logFilter(%d, +%d) = [%d, %d]", from, span, lo, hi)
+		}
+	})
+}
+
+func TestRPCCallResponseTooLarge(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"result":"` + strings.Repeat("f", 4096) + `"}`))
+	}))
+	defer srv.Close()
+
+	old := opts.maxResponse
+	opts.maxResponse = 1024
+	defer func() { opts.maxResponse = old }()
+
+	_, _, err := rpcCall(srv.URL, "eth_getLogs", logFilter(1, 2))
+	var tooLarge *ResponseTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Limit != 1024 {
+		t.Fatalf("err = %v, want ResponseTooLargeError{1024}", err)
+	}
+	if err.Error() != "response too large" {
+		t.Errorf("message = %q", err.Error())
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// configSchema prints a JSON Schema (draft-07) for config.toml, derived from
+// the toml tags of the config struct so new fields are picked up for free.
+func configSchema(args []string) {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: test_rpcs config-schema")
+		os.Exit(2)
+	}
+	s := typeSchema(reflect.TypeFor[config]())
+	s["$schema"] = "http://json-schema.org/draft-07/schema#"
+	s["title"] = "test_rpcs config.toml"
+	out, _ := json.MarshalIndent(s, "", "  ")
+	fmt.Println(string(out))
+}
+
+// typeSchema maps a Go type to its JSON Schema. Map keys are chain IDs in
+// every map the config uses, so they are constrained to decimal digits.
+func typeSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{
+			"type":                 "object",
+			"patternProperties":    map[string]any{"^[0-9]+$": typeSchema(t.Elem())},
+			"additionalProperties": false,
+		}
+	case reflect.Struct:
+		props := map[string]any{}
+		var required []string
+		for i := range t.NumField() {
+			f := t.Field(i)
+			tag := f.Tag.Get("toml")
+			if !f.IsExported() || tag == "-" {
+				continue
+			}
+			name, opt, _ := strings.Cut(tag, ",")
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = typeSchema(f.Type)
+			if !strings.Contains(opt, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		s := map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	return map[string]any{}
+}
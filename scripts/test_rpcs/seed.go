@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const chainlistURL = "https://chainid.network/chains.json"
+
+// chainlistEntry is the subset of a chains.json record we use.
+type chainlistEntry struct {
+	ChainID uint64   `json:"chainId"`
+	Name    string   `json:"name"`
+	RPC     []string `json:"rpc"`
+}
+
+// seedFromChainlist writes a starter config.toml listing every public
+// https:// RPC that chainlist knows for the requested chains.
+func seedFromChainlist(args []string) {
+	flags := flag.NewFlagSet("seed-from-chainlist", flag.ExitOnError)
+	chainsFlag := flags.String("chains", "", "comma-separated chain IDs or names to include (default: all known chains)")
+	out := flags.String("out", "config.toml", "`file` to write")
+	force := flags.Bool("force", false, "overwrite an existing file")
+	flags.Parse(args)
+
+	var ids []uint64
+	if *chainsFlag == "" {
+		ids = slices.Sorted(maps.Keys(chains))
+	} else {
+		for _, s := range strings.Split(*chainsFlag, ",") {
+			id, ok := chainID(s)
+			if !ok {
+				log.Fatalf("-chains: invalid chain ID %q", s)
+			}
+			ids = append(ids, id)
+		}
+	}
+
+	if !*force {
+		if _, err := os.Stat(*out); !errors.Is(err, fs.ErrNotExist) {
+			log.Fatalf("%s already exists; pass -force to overwrite", *out)
+		}
+	}
+
+	list, err := fetchChainlist()
+	if err != nil {
+		log.Fatalf("fetching %s: %v", chainlistURL, err)
+	}
+	byID := make(map[uint64]chainlistEntry, len(list))
+	for _, e := range list {
+		byID[e.ChainID] = e
+	}
+
+	var b strings.Builder
+	b.WriteString("# ERC-8004 events sync configuration.\n")
+	fmt.Fprintf(&b, "# Seeded from %s at %s; run test_rpcs -write to rank.\n\n",
+		chainlistURL, time.Now().UTC().Format("2006-01-02 15:04 UTC"))
+	for _, id := range ids {
+		e, ok := byID[id]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "  [%6d] not on chainlist, skipping\n", id)
+			continue
+		}
+		rpcs := publicRPCs(e.RPC)
+		fmt.Fprintf(&b, "[chains.%d]  # %s\n", id, e.Name)
+		meta, known := chains[id]
+		if known {
+			fmt.Fprintf(&b, "# deploy block %d\n", meta.DeployBlock)
+		}
+		b.WriteString("rpcs = [\n")
+		for _, u := range rpcs {
+			fmt.Fprintf(&b, "    %q,\n", u)
+		}
+		b.WriteString("]\n\n")
+		if !known {
+			// Without a [chain_meta] section the chain would be skipped.
+			fmt.Fprintf(&b, "[chain_meta.%d]\nname = %q\n", id, e.Name)
+			b.WriteString("deploy_block = 0  # TODO: the identity contract's deploy block; 0 scans from genesis\n\n")
+		}
+		fmt.Fprintf(os.Stderr, "  [%6d] %s: %d RPCs\n", id, e.Name, len(rpcs))
+	}
+
+	if err := writeFileAtomic(*out, []byte(b.String())); err != nil {
+		log.Fatalf("writing %s: %v", *out, err)
+	}
+	fmt.Fprintf(os.Stderr, "  ✅ Written to %s\n", *out)
+}
+
+func fetchChainlist() ([]chainlistEntry, error) {
+	resp, err := client.Get(chainlistURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	var list []chainlistEntry
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// publicRPCs keeps the https:// URLs that need no API key; chainlist marks
+// key placeholders as ${INFURA_API_KEY} and the like.
+func publicRPCs(urls []string) []string {
+	var out []string
+	for _, u := range urls {
+		if !strings.HasPrefix(u, "https://") || strings.Contains(u, "${") ||
+			strings.Contains(strings.ToUpper(u), "API_KEY") || slices.Contains(out, u) {
+			continue
+		}
+		out = append(out, u)
+	}
+	return out
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// resultStore holds the latest completed run for the server subcommand.
+type resultStore struct {
+	mu      sync.RWMutex
+	results map[uint64][]result
+}
+
+func (s *resultStore) set(results map[uint64][]result) {
+	for _, rs := range results {
+		sortResults(rs)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = results
+}
+
+func (s *resultStore) get() map[uint64][]result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.results
+}
+
+// serve runs the health check every interval and serves the latest results
+// as JSON:
+//
+//	GET /chains             chain IDs
+//	GET /chains/{id}        ranked results for a chain
+//	GET /chains/{id}/best   best archive endpoint URL, as text
+//	GET /health             200, or 503 before the first run or while any
+//	                        chain has no reachable endpoint
+func serve(args []string) {
+	flags := flag.NewFlagSet("server", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "listen `address`")
+	interval := flags.Duration("interval", 5*time.Minute, "re-test every `interval`")
+	configFlag := flags.String("config", "", "comma-separated config `files` to merge (default: config.toml in the working directory or above)")
+	mergeFlag := flags.String("config-merge-strategy", "append", "how later -config files combine a chain's rpcs with earlier ones: append or replace")
+	flags.Parse(args)
+
+	if *mergeFlag != "append" && *mergeFlag != "replace" {
+		log.Fatalf("-config-merge-strategy: unknown strategy %q (want append or replace)", *mergeFlag)
+	}
+	cfg, err := loadConfigs(configPaths(*configFlag), *mergeFlag)
+	if err != nil {
+		log.Fatalf("reading config: %v", err)
+	}
+	warnUnsetEnv(cfg)
+	progress = io.Discard
+	targets := buildTargets(cfg, nil)
+
+	store := new(resultStore)
+	go func() {
+		for {
+			start := time.Now()
+			store.set(testTargets(targets, 0))
+			log.Printf("tested %d chains in %s", len(targets), time.Since(start).Round(time.Second))
+			time.Sleep(*interval)
+		}
+	}()
+
+	log.Printf("serving on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, store.handler()))
+}
+
+func (s *resultStore) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /chains", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, slices.Sorted(maps.Keys(s.get())))
+	})
+	mux.HandleFunc("GET /chains/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if rs, ok := s.chain(w, r); ok {
+			writeJSON(w, rs)
+		}
+	})
+	mux.HandleFunc("GET /chains/{id}/best", func(w http.ResponseWriter, r *http.Request) {
+		rs, ok := s.chain(w, r)
+		if !ok {
+			return
+		}
+		best, ok := bestArchive(rs)
+		if !ok {
+			http.Error(w, "no archive endpoint", http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, best.URL+"\n")
+	})
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, _ *http.Request) {
+		all := s.get()
+		if all == nil {
+			http.Error(w, "first run in progress", http.StatusServiceUnavailable)
+			return
+		}
+		for _, cid := range slices.Sorted(maps.Keys(all)) {
+			if !slices.ContainsFunc(all[cid], func(r result) bool { return r.Reachable }) {
+				http.Error(w, "chain "+strconv.FormatUint(cid, 10)+": all endpoints unreachable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		io.WriteString(w, "ok\n")
+	})
+	return mux
+}
+
+// chain looks up the {id} path value, writing a 404 when it is unknown.
+func (s *resultStore) chain(w http.ResponseWriter, r *http.Request) ([]result, bool) {
+	cid, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	rs, ok := s.get()[cid]
+	if err != nil || !ok {
+		http.Error(w, "unknown chain", http.StatusNotFound)
+		return nil, false
+	}
+	return rs, true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
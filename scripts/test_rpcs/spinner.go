@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var spinFrames = []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
+
+// spinner redraws a single status line on a terminal listing the chains in
+// flight, printing each chain's final line above it as the chain completes.
+// A nil *spinner prints the start and finish lines to progress instead.
+type spinner struct {
+	mu     sync.Mutex
+	w      io.Writer
+	active []string
+	frame  int
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// spin is the run's spinner, nil when disabled.
+var spin *spinner
+
+// spinnerEnabled reports whether stderr is a terminal and colour and
+// animation have not been turned off with -no-color or NO_COLOR.
+func spinnerEnabled() bool {
+	if opts.noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	fi, err := os.Stderr.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func newSpinner(w io.Writer) *spinner {
+	s := &spinner{w: w, stop: make(chan struct{}), done: make(chan struct{})}
+	go s.run()
+	return s
+}
+
+func (s *spinner) run() {
+	defer close(s.done)
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-s.stop:
+			s.mu.Lock()
+			s.clear()
+			s.mu.Unlock()
+			return
+		case <-tick.C:
+			s.mu.Lock()
+			s.frame++
+			s.draw()
+			s.mu.Unlock()
+		}
+	}
+}
+
+// clear erases the status line; callers hold s.mu.
+func (s *spinner) clear() { fmt.Fprint(s.w, "\r\033[K") }
+
+// draw repaints the status line; callers hold s.mu.
+func (s *spinner) draw() {
+	s.clear()
+	if len(s.active) > 0 {
+		fmt.Fprintf(s.w, "  %c %s", spinFrames[s.frame%len(spinFrames)], strings.Join(s.active, ", "))
+	}
+}
+
+// start marks name as in flight.
+func (s *spinner) start(name, line string) {
+	if s == nil {
+		fmt.Fprint(progress, line)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active = append(s.active, name)
+	s.draw()
+}
+
+// finish replaces name's spinner with its final line.
+func (s *spinner) finish(name, line string) {
+	if s == nil {
+		fmt.Fprint(progress, line)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := slices.Index(s.active, name); i >= 0 {
+		s.active = slices.Delete(s.active, i, i+1)
+	}
+	s.clear()
+	fmt.Fprint(s.w, line)
+	s.draw()
+}
+
+// close stops the animation and erases the status line.
+func (s *spinner) close() {
+	close(s.stop)
+	<-s.done
+}
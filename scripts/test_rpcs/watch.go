@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// watch re-tests every chain on its own ticker until the process is killed,
+// printing the chain's table after each cycle. A chain's
+// check_interval_seconds overrides the global interval.
+func watch(targets []target, every time.Duration) {
+	if opts.warmup > 0 {
+		warmUp(targets, opts.warmup)
+	}
+	var mu sync.Mutex // serializes table output across chains
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		interval := every
+		if t.cfg.CheckIntervalSeconds > 0 {
+			interval = time.Duration(t.cfg.CheckIntervalSeconds) * time.Second
+		}
+		wg.Go(func() {
+			tick := time.NewTicker(interval)
+			defer tick.Stop()
+			var prev []result
+			latencies := map[string][]float64{}
+			for {
+				results := testChain(t)
+				if len(opts.webhooks) > 0 {
+					if prev != nil {
+						sendAlerts(statusAlerts(t.cid, prev, results))
+					}
+					if opts.alertLatencyPct > 0 {
+						sendAlerts(latencyAlerts(t.cid, latencies, results, opts.alertLatencyPct))
+					}
+				}
+				prev = results
+				mu.Lock()
+				fmt.Printf("\n  %s — next check in %s\n", time.Now().UTC().Format("15:04:05 UTC"), interval)
+				printChain(t.cid, t.meta, results)
+				mu.Unlock()
+				<-tick.C
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// warmUp runs silent test cycles over all targets, discarding the results,
+// until d has elapsed (at least one cycle), so the first reported cycle does
+// not carry DNS, connection and TCP slow-start costs.
+func warmUp(targets []target, d time.Duration) {
+	fmt.Fprintf(os.Stderr, "  warming up for %s ...\n", d)
+	saved := progress
+	progress = io.Discard
+	defer func() { progress = saved }()
+	for deadline := time.Now().Add(d); ; {
+		var wg sync.WaitGroup
+		for _, t := range targets {
+			wg.Go(func() { testChain(t) })
+		}
+		wg.Wait()
+		if !time.Now().Before(deadline) {
+			return
+		}
+	}
+}
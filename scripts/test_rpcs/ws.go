@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var wsDialer = &websocket.Dialer{
+	NetDialContext:   dialContext,
+	Proxy:            http.ProxyFromEnvironment,
+	HandshakeTimeout: 10 * time.Second,
+}
+
+func isWebSocket(url string) bool {
+	return strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "wss://")
+}
+
+// wsDial opens a WebSocket connection. The dialer rejects URLs with
+// userinfo, so credentials move to a Basic Authorization header on the
+// handshake, as newRPCRequest does for HTTP.
+func wsDial(rawURL string) (*websocket.Conn, error) {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var h http.Header
+	if user := u.User; user != nil {
+		pass, _ := user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + pass))
+		h = http.Header{"Authorization": {"Basic " + auth}}
+		u.User = nil
+	}
+	conn, _, err := wsDialer.Dial(u.String(), h)
+	return conn, err
+}
+
+// wsRoundTrip sends one request on a fresh connection and returns the first
+// message received. The elapsed time includes the handshake.
+func wsRoundTrip(url string, body []byte, timeout time.Duration) (reply, error) {
+	t0 := time.Now()
+	conn, err := wsDial(url)
+	if err != nil {
+		return reply{elapsed: time.Since(t0)}, err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(t0.Add(timeout))
+	conn.SetReadDeadline(t0.Add(timeout))
+	conn.SetReadLimit(responseLimit())
+	if err := conn.WriteMessage(websocket.TextMessage, body); err != nil {
+		return reply{elapsed: time.Since(t0)}, err
+	}
+	_, data, err := conn.ReadMessage()
+	if errors.Is(err, websocket.ErrReadLimit) {
+		err = &ResponseTooLargeError{responseLimit()}
+	}
+	return reply{data: data, elapsed: time.Since(t0)}, err
+}
+
+// wsSubscribe opens an eth_subscribe subscription with params, waits up to
+// wait for the first notification, then unsubscribes.
+func wsSubscribe(url string, params []any, wait time.Duration) error {
+	conn, err := wsDial(url)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(client.Timeout)
+	conn.SetWriteDeadline(deadline)
+	conn.SetReadDeadline(deadline)
+	if err := conn.WriteJSON(rpcReq{"2.0", nextID(), "eth_subscribe", params}); err != nil {
+		return err
+	}
+	var sub struct {
+		Result string    `json:"result"`
+		Error  *rpcError `json:"error"`
+	}
+	if err := conn.ReadJSON(&sub); err != nil {
+		return err
+	}
+	if sub.Error != nil {
+		return errors.New(sub.Error.Message)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(wait))
+	var note struct {
+		Method string `json:"method"`
+	}
+	if err := conn.ReadJSON(&note); err != nil {
+		return err
+	}
+	if note.Method != "eth_subscription" {
+		return errors.New("unexpected message " + note.Method)
+	}
+	return conn.WriteJSON(rpcReq{"2.0", nextID(), "eth_unsubscribe", []any{sub.Result}})
+}
+
+// checkSubscribe reports whether a newHeads subscription delivers a header
+// within five seconds. HTTP endpoints cannot subscribe and report false.
+func checkSubscribe(url string) bool {
+	return isWebSocket(url) && wsSubscribe(url, []any{"newHeads"}, 5*time.Second) == nil
+}
+
+// logSubscribeWait bounds -check-subscribe-logs; the identity contract may
+// emit nothing in that window.
+const logSubscribeWait = 30 * time.Second
+
+// checkSubscribeLogs subscribes to the identity contract's logs and waits for
+// one notification. A timeout is reported as a note rather than an error,
+// since the contract may simply have been idle.
+func checkSubscribeLogs(url string) (ok bool, note string) {
+	if !isWebSocket(url) {
+		return false, "not a WebSocket endpoint"
+	}
+	err := wsSubscribe(url, []any{"logs", map[string]string{"address": identityAddr}}, logSubscribeWait)
+	var ne net.Error
+	switch {
+	case err == nil:
+		return true, ""
+	case errors.As(err, &ne) && ne.Timeout():
+		return false, "no log within 30s (may be idle)"
+	default:
+		return false, truncate(redactEnv(err.Error()), 200)
+	}
+}